@@ -4,9 +4,12 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/StrongerSoftworks/image-proxy/internal/handlers"
+	"github.com/StrongerSoftworks/image-proxy/internal/imghttp"
+	"github.com/StrongerSoftworks/image-proxy/internal/transformations"
 	"github.com/joho/godotenv"
 )
 
@@ -69,6 +72,27 @@ func main() {
 	allowedURLs := os.Getenv("ALLOWED_ORIGINS")
 	storageMode := os.Getenv("STORAGE_MODE")
 
+	if err := transformations.LoadSettings(); err != nil {
+		log.Fatalf("Error loading settings: %v", err)
+	}
+
+	if err := imghttp.LoadSettings(); err != nil {
+		log.Fatalf("Error loading settings: %v", err)
+	}
+
+	srcsetConfig, err := handlers.LoadSrcsetConfig()
+	if err != nil {
+		log.Fatalf("Error loading settings: %v", err)
+	}
+
+	maxUploadBytes := int64(10 << 20)
+	if value := os.Getenv("MAX_UPLOAD_BYTES"); value != "" {
+		maxUploadBytes, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || maxUploadBytes <= 0 {
+			log.Fatalf("Error loading settings: invalid MAX_UPLOAD_BYTES: %s", value)
+		}
+	}
+
 	var requestHandler handlers.ImageProxyRequestHandler
 	if storageMode == "s3" {
 		requestHandler = handlers.NewS3RequestHanlder()
@@ -83,5 +107,26 @@ func main() {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/proxy", requestHandler.Handler)
+	mux.HandleFunc("/info", handlers.InfoHandler(requestHandler))
+	mux.HandleFunc("/palette", handlers.PaletteHandler(requestHandler))
+	mux.HandleFunc("/srcset", handlers.SrcsetHandler(requestHandler, srcsetConfig))
+	mux.HandleFunc("/batch", handlers.BatchHandler(requestHandler, srcsetConfig.BaseURL))
+	mux.HandleFunc("/transform", handlers.TransformHandler(requestHandler, maxUploadBytes))
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		mux.HandleFunc("/admin/purge", handlers.PurgeHandler(requestHandler, adminToken))
+	}
+	mux.HandleFunc("/", handlers.PathHandler(requestHandler.Handler))
+	for _, route := range strings.Split(os.Getenv("COMPAT_ROUTES"), ",") {
+		switch strings.TrimSpace(route) {
+		case "":
+		case "imgix":
+			mux.HandleFunc("/imgix/", handlers.ImgixHandler(requestHandler.Handler, "/imgix", os.Getenv("IMGIX_ORIGIN")))
+		case "thumbor":
+			mux.HandleFunc("/thumbor/", handlers.ThumborHandler(requestHandler.Handler, "/thumbor",
+				os.Getenv("THUMBOR_ORIGIN"), os.Getenv("THUMBOR_SECURITY_KEY")))
+		default:
+			log.Fatalf("Unknown compatibility route: %s", route)
+		}
+	}
 	http.ListenAndServe(":8080", healthCheckMiddleware(requestFilterMiddleware(mux, strings.Split(allowedURLs, ","))))
 }
@@ -4,9 +4,17 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/StrongerSoftworks/image-proxy/internal/compat"
+	"github.com/StrongerSoftworks/image-proxy/internal/handlers"
 	"github.com/StrongerSoftworks/image-proxy/internal/imghttp"
 	"github.com/StrongerSoftworks/image-proxy/internal/imgs3"
+	"github.com/StrongerSoftworks/image-proxy/internal/imgurl"
+	"github.com/StrongerSoftworks/image-proxy/internal/originals"
 	"github.com/StrongerSoftworks/image-proxy/internal/transformations"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -15,10 +23,79 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
+// s3Handler stores the revalidation records of derivatives, like the S3 mode of the HTTP server
+var s3Handler handlers.ImageProxyRequestHandler
+
+var revalidateInterval time.Duration
+
 func main() {
+	if err := transformations.LoadSettings(); err != nil {
+		log.Fatalf("Error loading settings: %v", err)
+	}
+	if err := imghttp.LoadSettings(); err != nil {
+		log.Fatalf("Error loading settings: %v", err)
+	}
+	s3Handler = handlers.NewS3RequestHanlder()
+	s3Handler.Init()
+	revalidateInterval = handlers.LoadRevalidateInterval()
 	lambda.Start(handler)
 }
 
+func queryParameter(parameters map[string]string) func(string) string {
+	return func(name string) string {
+		return parameters[name]
+	}
+}
+
+// API Gateway does not normalise header names so they are matched case-insensitively
+func header(request events.APIGatewayProxyRequest) func(string) string {
+	return func(name string) string {
+		for key, value := range request.Headers {
+			if strings.EqualFold(key, name) {
+				return value
+			}
+		}
+		return ""
+	}
+}
+
+func responseHeaders(options *transformations.Options, imgData []byte) map[string]string {
+	headers := imghttp.ImageHeaders(options.Format, imgData)
+	for key, value := range transformations.ResponseHeaders(options) {
+		headers[key] = value
+	}
+	return headers
+}
+
+// translates a path-encoded or compatibility route request into /proxy parameters.
+// The imgix and thumbor routes are enabled by COMPAT_ROUTES.
+func pathParameters(request events.APIGatewayProxyRequest) (url.Values, error) {
+	compatRoutes := map[string]bool{}
+	for _, route := range strings.Split(os.Getenv("COMPAT_ROUTES"), ",") {
+		compatRoutes[strings.TrimSpace(route)] = true
+	}
+
+	if imgPath, found := strings.CutPrefix(request.Path, "/imgix/"); found && compatRoutes["imgix"] {
+		query := url.Values{}
+		for name, value := range request.QueryStringParameters {
+			query.Set(name, value)
+		}
+		return compat.FromImgix(os.Getenv("IMGIX_ORIGIN"), imgPath, query)
+	}
+	if thumborPath, found := strings.CutPrefix(request.Path, "/thumbor"); found && compatRoutes["thumbor"] {
+		return compat.FromThumbor(os.Getenv("THUMBOR_ORIGIN"), os.Getenv("THUMBOR_SECURITY_KEY"), thumborPath)
+	}
+	// API Gateway decodes the path, so it is escaped again for ParsePath. Commas
+	// and slashes that were percent-encoded in option values can not be told
+	// apart anymore.
+	escapedPath := (&url.URL{Path: request.Path}).EscapedPath()
+	query := url.Values{}
+	for name, value := range request.QueryStringParameters {
+		query.Set(name, value)
+	}
+	return imgurl.ParsePath(escapedPath, query.Encode())
+}
+
 func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 
 	// Set up AWS connections
@@ -31,14 +108,20 @@ func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events
 	client := s3.NewFromConfig(cfg)
 	uploader := manager.NewUploader(client)
 
-	// Extract query parameters
-	imgPath := request.QueryStringParameters["img"]
-	widthQuery := request.QueryStringParameters["width"]
-	heightQuery := request.QueryStringParameters["height"]
-	aspectRatioQuery := request.QueryStringParameters["ratio"]
-	modeQuery := request.QueryStringParameters["mode"]
-	formatQuery := request.QueryStringParameters["format"]
-	qualityQuery := request.QueryStringParameters["quality"]
+	// Extract query parameters. Requests without an img parameter may use the
+	// path-encoded API, query parameter requests are served on any path as before.
+	parameters := request.QueryStringParameters
+	if parameters["img"] == "" && request.Path != "" && request.Path != "/proxy" {
+		if values, err := pathParameters(request); err != nil {
+			log.Printf("Issue parsing image path, using the query parameters: %v", err)
+		} else {
+			parameters = map[string]string{}
+			for name := range values {
+				parameters[name] = values.Get(name)
+			}
+		}
+	}
+	imgPath := parameters["img"]
 
 	format, err := transformations.FormatFromPath(imgPath)
 	if err != nil {
@@ -52,19 +135,19 @@ func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events
 		Mode:    "fit",
 		Format:  format,
 	}
-	err = transformations.ParseOptions(widthQuery, heightQuery, formatQuery, modeQuery, qualityQuery, aspectRatioQuery, &options)
+	err = transformations.ParseQuery(queryParameter(parameters), header(request), &options)
 	if err != nil {
 		log.Printf("Issue parsing options: %v", err)
-		return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError}, err
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest}, nil
 	}
 
-	// Check if file exists
+	// Check if file exists and its original has not changed
 	s3FileKey := imgs3.MakeBucketFileKey(imgPath, &options)
 
 	if exists, existsErr := imgs3.ImageExists(ctx, client, bucket, s3FileKey); existsErr != nil {
 		log.Printf("Error getting format from file URL: %v", err)
 		return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError}, err
-	} else if exists {
+	} else if exists && !handlers.NeedsRegeneration(s3Handler, imgPath, s3FileKey, revalidateInterval) {
 		// Retrieve image from bucket
 		imgData, err := imgs3.GetImage(ctx, client, bucket, s3FileKey)
 		if err != nil {
@@ -73,14 +156,22 @@ func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events
 		}
 		return events.APIGatewayProxyResponse{
 			StatusCode: http.StatusOK,
-			Headers:    imghttp.ImageHeaders(options.Format, imgData),
+			Headers:    responseHeaders(&options, imgData),
 			Body:       string(imgData),
 			// IsBase64Encoded: true,
 		}, nil
 	}
 
-	// Get the image from source
-	img, _, err := imghttp.GetImage(imgPath)
+	// Get the image from source, revalidating the cached original when enabled
+	var originalsStore originals.Store
+	if os.Getenv("ORIGINALS_CACHE") == "true" {
+		originalsStore = &imgs3.OriginalStore{Client: client, Uploader: uploader, Bucket: bucket, Prefix: imgs3.GetOriginalsPrefix()}
+	}
+	original, err := originals.New(originalsStore).Get(imgPath)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError}, err
+	}
+	img, _, err := imghttp.DecodeImage(original.Data)
 	if err != nil {
 		return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError}, err
 	}
@@ -98,11 +189,14 @@ func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events
 		log.Printf("Error uploading file: %v", err)
 		return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError}, err
 	}
+	if err := handlers.RecordSource(s3Handler, s3FileKey, original.Validators); err != nil {
+		log.Printf("Error saving source record: %v", err)
+	}
 
 	// Return the transformed image
 	return events.APIGatewayProxyResponse{
 		StatusCode: http.StatusOK,
-		Headers:    imghttp.ImageHeaders(options.Format, imgData.Bytes()),
+		Headers:    responseHeaders(&options, imgData.Bytes()),
 		Body:       imgData.String(),
 		// IsBase64Encoded: true,
 	}, nil
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/handlers"
+	"github.com/joho/godotenv"
+)
+
+// deletes every cached derivative, metadata file and original of a source url,
+// or of every source starting with it, from the storage selected by STORAGE_MODE
+func main() {
+	prefix := flag.Bool("prefix", false, "purge every source starting with the url")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-prefix] <source url>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// The environment file is optional, the variables may be set directly
+	envFile := ".env"
+	if env := os.Getenv("GO_ENV"); env == "production" || env == "development" {
+		envFile = ".env." + env
+	}
+	if err := godotenv.Load(envFile); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Error loading %s file: %v", envFile, err)
+	}
+
+	// The local store needs no setup to purge, its Init would also start the
+	// disk cache janitor and evict files
+	var requestHandler handlers.ImageProxyRequestHandler
+	if os.Getenv("STORAGE_MODE") == "s3" {
+		requestHandler = handlers.NewS3RequestHanlder()
+		requestHandler.Init()
+	} else {
+		requestHandler = handlers.NewLocalRequestHandler()
+	}
+
+	deleted, err := handlers.Purge(requestHandler, flag.Arg(0), *prefix)
+	if err != nil {
+		log.Fatalf("Error purging %s: %v", flag.Arg(0), err)
+	}
+	fmt.Printf("Deleted %d files\n", deleted)
+}
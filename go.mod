@@ -12,6 +12,7 @@ require (
 	github.com/disintegration/imaging v1.6.2
 	github.com/gen2brain/avif v0.4.2
 	github.com/joho/godotenv v1.5.1
+	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
 )
 
 require (
@@ -32,5 +33,5 @@ require (
 	github.com/aws/smithy-go v1.22.2 // indirect
 	github.com/ebitengine/purego v0.8.1 // indirect
 	github.com/tetratelabs/wazero v1.8.1 // indirect
-	golang.org/x/image v0.0.0-20211028202545-6944b10bf410 // indirect
+	golang.org/x/text v0.3.6 // indirect
 )
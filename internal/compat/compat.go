@@ -0,0 +1,271 @@
+package compat
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// imgix parameters that translate directly to a /proxy parameter
+var imgixNames = map[string]string{
+	"w":             "width",
+	"h":             "height",
+	"q":             "quality",
+	"dpr":           "dpr",
+	"rot":           "rotate",
+	"flip":          "flip",
+	"bri":           "brightness",
+	"con":           "contrast",
+	"sat":           "saturation",
+	"rect":          "rect",
+	"bg":            "bg",
+	"px":            "pixelate",
+	"txt":           "text",
+	"txtsize":       "text_size",
+	"txtclr":        "text_color",
+	"trimcolor":     "trim_color",
+	"trimtol":       "trim",
+	"corner-radius": "radius",
+}
+
+// imgix output formats with an equivalent /proxy format
+var imgixFormats = map[string]string{
+	"jpg":      "jpg",
+	"pjpg":     "jpg",
+	"png":      "png",
+	"png8":     "png",
+	"png32":    "png",
+	"webp":     "webp",
+	"avif":     "avif",
+	"blurhash": "blurhash",
+}
+
+// translates an imgix request into /proxy query parameters. imgPath is the
+// request path, resolved against origin. Parameters and values without an
+// equivalent are ignored so existing URLs keep working, e.g. an unsupported fit
+// falls back to clip and an unsupported fm to the source format.
+func FromImgix(origin string, imgPath string, query url.Values) (url.Values, error) {
+	values := url.Values{}
+	values.Set("img", resolve(origin, imgPath))
+
+	for name, value := range query {
+		if longName, exists := imgixNames[name]; exists {
+			values.Set(longName, value[0])
+		}
+	}
+
+	switch query.Get("fit") {
+	case "max":
+		values.Set("mode", "fit")
+	case "crop":
+		values.Set("mode", "fill")
+		values.Set("enlarge", "true")
+	case "min":
+		values.Set("mode", "fill")
+	case "fill":
+		values.Set("mode", "pad")
+		values.Set("enlarge", "true")
+		if fillColor := query.Get("fill-color"); fillColor != "" {
+			values.Set("bg", fillColor)
+		}
+	default:
+		// clip is the imgix default and scales up to the requested size
+		values.Set("mode", "fit")
+		values.Set("enlarge", "true")
+	}
+
+	format, supported := imgixFormats[query.Get("fm")]
+	if supported {
+		values.Set("format", format)
+	}
+	if strings.Contains(query.Get("auto"), "format") && !supported {
+		values.Set("format", "auto")
+	}
+
+	if query.Get("monochrome") != "" || query.Get("sat") == "-100" {
+		values.Del("saturation")
+		values.Set("grayscale", "true")
+	}
+	if query.Get("trim") == "color" || query.Get("trim") == "auto" {
+		if values.Get("trim") == "" {
+			values.Set("trim", "true")
+		}
+	}
+	if query.Get("mask") == "ellipse" {
+		values.Set("radius", "max")
+	}
+	if blur := query.Get("blur"); blur != "" {
+		// imgix blur runs from 0 to 2000, roughly a tenth of that as a gaussian sigma
+		amount, err := strconv.ParseFloat(blur, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blur: %s", blur)
+		}
+		values.Set("blur", strconv.FormatFloat(min(amount/10, 50), 'f', -1, 64))
+	}
+
+	return values, nil
+}
+
+// /[unsafe|signature]/[trim/][AxB:CxD/][fit-in/][-]Wx[-]H/[halign/][valign/][smart/][filters:.../]image
+var thumborPattern = regexp.MustCompile(`^(?:(trim(?::[^/]+)?)/)?` +
+	`(?:(\d+)x(\d+):(\d+)x(\d+)/)?` +
+	`(?:(fit-in)/)?` +
+	`(?:(-?)(\d*)x(-?)(\d*)/)?` +
+	`(?:(?:left|right|center)/)?` +
+	`(?:(?:top|bottom|middle)/)?` +
+	`(?:smart/)?` +
+	`(?:filters:([^/]+)/)?` +
+	`(.+)$`)
+
+var thumborFilterPattern = regexp.MustCompile(`([a-z_]+)\(([^)]*)\)`)
+
+// translates a Thumbor path into /proxy query parameters. With a security key
+// only correctly signed paths are accepted, otherwise paths must start with
+// unsafe/. Images without a scheme are resolved against origin.
+func FromThumbor(origin string, securityKey string, thumborPath string) (url.Values, error) {
+	signature, rest, found := strings.Cut(strings.TrimPrefix(thumborPath, "/"), "/")
+	if !found {
+		return nil, fmt.Errorf("expected /<signature>/<image>")
+	}
+	if securityKey == "" {
+		if signature != "unsafe" {
+			return nil, fmt.Errorf("only unsafe urls are accepted")
+		}
+	} else if !validThumborSignature(securityKey, signature, rest) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	match := thumborPattern.FindStringSubmatch(rest)
+	if match == nil {
+		return nil, fmt.Errorf("invalid thumbor url")
+	}
+
+	values := url.Values{}
+	values.Set("img", resolve(origin, match[12]))
+
+	if match[1] != "" {
+		values.Set("trim", "true")
+	}
+
+	if match[2] != "" {
+		left, _ := strconv.Atoi(match[2])
+		top, _ := strconv.Atoi(match[3])
+		right, _ := strconv.Atoi(match[4])
+		bottom, _ := strconv.Atoi(match[5])
+		if right <= left || bottom <= top {
+			return nil, fmt.Errorf("invalid crop: %sx%s:%sx%s", match[2], match[3], match[4], match[5])
+		}
+		values.Set("rect", fmt.Sprintf("%d,%d,%d,%d", left, top, right-left, bottom-top))
+	}
+
+	// Thumbor fills and upscales by default, fit-in only upscales with the upscale() filter
+	fitIn := match[6] != ""
+	if fitIn {
+		values.Set("mode", "fit")
+	} else {
+		values.Set("mode", "fill")
+		values.Set("enlarge", "true")
+	}
+
+	if match[8] != "" && match[8] != "0" {
+		values.Set("width", match[8])
+	}
+	if match[10] != "" && match[10] != "0" {
+		values.Set("height", match[10])
+	}
+	switch {
+	case match[7] != "" && match[9] != "":
+		values.Set("flip", "hv")
+	case match[7] != "":
+		values.Set("flip", "h")
+	case match[9] != "":
+		values.Set("flip", "v")
+	}
+
+	for _, filter := range thumborFilterPattern.FindAllStringSubmatch(match[11], -1) {
+		if err := applyThumborFilter(filter[1], strings.Split(filter[2], ","), values); err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+func applyThumborFilter(name string, args []string, values url.Values) error {
+	switch name {
+	case "quality":
+		values.Set("quality", args[0])
+	case "format":
+		values.Set("format", args[0])
+	case "blur":
+		// blur(radius[,sigma]), the sigma defaults to the radius
+		values.Set("blur", args[len(args)-1])
+	case "brightness":
+		values.Set("brightness", args[0])
+	case "contrast":
+		values.Set("contrast", args[0])
+	case "saturation":
+		values.Set("saturation", args[0])
+	case "grayscale":
+		values.Set("grayscale", "true")
+	case "rotate":
+		values.Set("rotate", args[0])
+	case "fill":
+		values.Set("bg", args[0])
+	case "round_corner":
+		// round_corner(a|b,r,g,b), elliptical radii are approximated by a
+		radius, _, _ := strings.Cut(args[0], "|")
+		values.Set("radius", radius)
+		if len(args) >= 4 {
+			values.Set("bg", rgbToHex(args[1:4]))
+		}
+	case "sharpen":
+		// sharpen(amount,radius,luminance_only)
+		if len(args) >= 2 {
+			values.Set("sharpen", args[1])
+			values.Set("sharpen_amount", args[0])
+		}
+	case "upscale":
+		values.Set("enlarge", "true")
+	case "no_upscale":
+		values.Set("enlarge", "false")
+	case "watermark":
+		return fmt.Errorf("unsupported filter: watermark, use a configured watermark instead")
+	}
+	return nil
+}
+
+func rgbToHex(channels []string) string {
+	var hex strings.Builder
+	for _, channel := range channels {
+		value, _ := strconv.Atoi(strings.TrimSpace(channel))
+		fmt.Fprintf(&hex, "%02x", min(max(value, 0), 255))
+	}
+	return hex.String()
+}
+
+func validThumborSignature(securityKey string, signature string, signed string) bool {
+	mac := hmac.New(sha1.New, []byte(securityKey))
+	mac.Write([]byte(signed))
+	expected := base64.URLEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// resolves an image path without a scheme against origin
+func resolve(origin string, imgPath string) string {
+	for _, scheme := range []string{"http:/", "https:/"} {
+		if rest, found := strings.CutPrefix(imgPath, scheme); found {
+			// Path cleaning may have collapsed the "//" after the scheme
+			return scheme + "/" + strings.TrimPrefix(rest, "/")
+		}
+	}
+	if origin == "" {
+		return "https://" + strings.TrimPrefix(imgPath, "/")
+	}
+	return strings.TrimSuffix(origin, "/") + "/" + strings.TrimPrefix(imgPath, "/")
+}
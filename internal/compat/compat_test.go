@@ -0,0 +1,180 @@
+package compat
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestFromImgix(t *testing.T) {
+	tests := []struct {
+		name    string
+		imgPath string
+		query   string
+		want    url.Values
+		wantErr bool
+	}{
+		{
+			name:    "Size and format",
+			imgPath: "/products/a.jpg",
+			query:   "w=300&h=200&fm=webp&q=75",
+			want: url.Values{"img": {"https://origin.example.com/products/a.jpg"}, "width": {"300"}, "height": {"200"},
+				"format": {"webp"}, "quality": {"75"}, "mode": {"fit"}, "enlarge": {"true"}},
+		},
+		{
+			name:    "Crop fit",
+			imgPath: "/a.jpg",
+			query:   "w=100&h=100&fit=crop",
+			want: url.Values{"img": {"https://origin.example.com/a.jpg"}, "width": {"100"}, "height": {"100"},
+				"mode": {"fill"}, "enlarge": {"true"}},
+		},
+		{
+			name:    "Max fit does not upscale",
+			imgPath: "/a.jpg",
+			query:   "w=100&fit=max",
+			want:    url.Values{"img": {"https://origin.example.com/a.jpg"}, "width": {"100"}, "mode": {"fit"}},
+		},
+		{
+			name:    "Auto format",
+			imgPath: "/a.jpg",
+			query:   "w=100&fit=max&auto=compress,format",
+			want:    url.Values{"img": {"https://origin.example.com/a.jpg"}, "width": {"100"}, "mode": {"fit"}, "format": {"auto"}},
+		},
+		{
+			name:    "Explicit format wins over auto",
+			imgPath: "/a.jpg",
+			query:   "fit=max&auto=format&fm=pjpg",
+			want:    url.Values{"img": {"https://origin.example.com/a.jpg"}, "mode": {"fit"}, "format": {"jpg"}},
+		},
+		{
+			name:    "Adjustments and masks",
+			imgPath: "/a.png",
+			query:   "fit=max&rot=90&flip=h&monochrome=000&mask=ellipse&blur=100&ixlib=js-3.0",
+			want: url.Values{"img": {"https://origin.example.com/a.png"}, "mode": {"fit"}, "rotate": {"90"}, "flip": {"h"},
+				"grayscale": {"true"}, "radius": {"max"}, "blur": {"10"}},
+		},
+		{
+			name:    "Fill fit pads",
+			imgPath: "/a.jpg",
+			query:   "w=100&h=100&fit=fill&fill-color=ff0000",
+			want: url.Values{"img": {"https://origin.example.com/a.jpg"}, "width": {"100"}, "height": {"100"},
+				"mode": {"pad"}, "enlarge": {"true"}, "bg": {"ff0000"}},
+		},
+		{
+			name:    "Unsupported fit falls back to clip",
+			imgPath: "/a.jpg",
+			query:   "w=100&fit=facearea",
+			want: url.Values{"img": {"https://origin.example.com/a.jpg"}, "width": {"100"}, "mode": {"fit"},
+				"enlarge": {"true"}},
+		},
+		{
+			name:    "Unsupported format keeps the source format",
+			imgPath: "/a.png",
+			query:   "fit=max&fm=gif",
+			want:    url.Values{"img": {"https://origin.example.com/a.png"}, "mode": {"fit"}},
+		},
+		{
+			name:    "Unsupported format with auto",
+			imgPath: "/a.png",
+			query:   "fit=max&fm=jxr&auto=format",
+			want:    url.Values{"img": {"https://origin.example.com/a.png"}, "mode": {"fit"}, "format": {"auto"}},
+		},
+		{
+			name:    "Paletted png",
+			imgPath: "/a.png",
+			query:   "fit=max&fm=png8",
+			want:    url.Values{"img": {"https://origin.example.com/a.png"}, "mode": {"fit"}, "format": {"png"}},
+		},
+		{
+			name:    "Invalid blur",
+			imgPath: "/a.jpg",
+			query:   "blur=soft",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := FromImgix("https://origin.example.com", tt.imgPath, query)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("FromImgix() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FromImgix() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromThumbor(t *testing.T) {
+	tests := []struct {
+		name        string
+		securityKey string
+		path        string
+		want        url.Values
+		wantErr     bool
+	}{
+		{
+			name: "Fill with filters",
+			path: "/unsafe/300x200/smart/filters:quality(80):format(webp)/example.com/a.jpg",
+			want: url.Values{"img": {"https://example.com/a.jpg"}, "width": {"300"}, "height": {"200"}, "mode": {"fill"},
+				"enlarge": {"true"}, "quality": {"80"}, "format": {"webp"}},
+		},
+		{
+			name: "Fit in without upscaling",
+			path: "/unsafe/fit-in/300x0/https:/example.com/a.jpg",
+			want: url.Values{"img": {"https://example.com/a.jpg"}, "width": {"300"}, "mode": {"fit"}},
+		},
+		{
+			name: "Manual crop and flips",
+			path: "/unsafe/trim/10x20:110x220/-100x-50/left/top/https://example.com/a.jpg",
+			want: url.Values{"img": {"https://example.com/a.jpg"}, "trim": {"true"}, "rect": {"10,20,100,200"},
+				"mode": {"fill"}, "enlarge": {"true"}, "width": {"100"}, "height": {"50"}, "flip": {"hv"}},
+		},
+		{
+			name: "Round corners and no upscale",
+			path: "/unsafe/200x200/filters:round_corner(20,255,255,255):no_upscale():grayscale()/a.png",
+			want: url.Values{"img": {"https://a.png"}, "width": {"200"}, "height": {"200"}, "mode": {"fill"},
+				"enlarge": {"false"}, "radius": {"20"}, "bg": {"ffffff"}, "grayscale": {"true"}},
+		},
+		{
+			name:        "Signed url",
+			securityKey: "secret",
+			path:        "/3r7926gt0qr09Nkc-AOETMTwzCs=/300x200/example.com/a.jpg",
+			want: url.Values{"img": {"https://example.com/a.jpg"}, "width": {"300"}, "height": {"200"}, "mode": {"fill"},
+				"enlarge": {"true"}},
+		},
+		{
+			name:        "Bad signature",
+			securityKey: "secret",
+			path:        "/unsafe/300x200/example.com/a.jpg",
+			wantErr:     true,
+		},
+		{
+			name:    "Signed url without a key",
+			path:    "/3r7926gt0qr09Nkc-AOETMTwzCs=/300x200/example.com/a.jpg",
+			wantErr: true,
+		},
+		{
+			name:    "Watermark filter",
+			path:    "/unsafe/filters:watermark(evil.png,0,0,0)/example.com/a.jpg",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromThumbor("", tt.securityKey, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("FromThumbor() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FromThumbor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package diskcache
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eviction stops once usage is below this share of the limit, so a full cache
+// is not evicted again on every write
+const lowWaterMark = 0.9
+
+// rescans correct the usage after files were changed outside the janitor,
+// e.g. by the purge CLI
+const rescanInterval = 10 * time.Minute
+
+type entry struct {
+	size       int64
+	accessedAt time.Time
+}
+
+// Janitor keeps the files under a set of directories within a size limit,
+// evicting the least recently accessed files first. Accesses are tracked in
+// memory and the index is rebuilt from modification times by Scan. A nil Janitor tracks
+// nothing, so callers do not need to check whether a limit is configured.
+type Janitor struct {
+	dirs     []string
+	maxBytes int64
+
+	mutex   sync.Mutex
+	entries map[string]*entry
+	usage   int64
+
+	evict chan struct{}
+}
+
+// creates a janitor of the files under dirs, maxBytes must be positive
+func NewJanitor(maxBytes int64, dirs ...string) *Janitor {
+	return &Janitor{
+		dirs:     dirs,
+		maxBytes: maxBytes,
+		entries:  map[string]*entry{},
+		evict:    make(chan struct{}, 1),
+	}
+}
+
+// scans the directories, evicts down to the limit and keeps evicting in the
+// background whenever a write exceeds it
+func (janitor *Janitor) Start() error {
+	if err := janitor.Scan(); err != nil {
+		return err
+	}
+	log.Printf("Disk cache uses %d of %d bytes", janitor.Usage(), janitor.maxBytes)
+	janitor.Evict()
+
+	go func() {
+		ticker := time.NewTicker(rescanInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-janitor.evict:
+			case <-ticker.C:
+				if err := janitor.Scan(); err != nil {
+					log.Printf("Error scanning disk cache: %v", err)
+				}
+			}
+			janitor.Evict()
+		}
+	}()
+	return nil
+}
+
+// rebuilds the index from the files on disk. Known files keep their access
+// time, new files are considered accessed when they were last modified.
+func (janitor *Janitor) Scan() error {
+	scanStart := time.Now()
+	found := map[string]*entry{}
+	for _, dir := range janitor.dirs {
+		err := filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+			// Files may be deleted while the scan runs, e.g. by a purge
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil || d.IsDir() {
+				return err
+			}
+			info, err := d.Info()
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			found[filePath] = &entry{size: info.Size(), accessedAt: info.ModTime()}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	janitor.mutex.Lock()
+	defer janitor.mutex.Unlock()
+	janitor.usage = 0
+	for filePath, found := range found {
+		if known, exists := janitor.entries[filePath]; exists && known.accessedAt.After(found.accessedAt) {
+			found.accessedAt = known.accessedAt
+		}
+		janitor.usage += found.size
+	}
+	// Files written while the scan ran may have been missed
+	for filePath, known := range janitor.entries {
+		if _, exists := found[filePath]; !exists && known.accessedAt.After(scanStart) {
+			found[filePath] = known
+			janitor.usage += known.size
+		}
+	}
+	janitor.entries = found
+	return nil
+}
+
+// records a read of the file at filePath
+func (janitor *Janitor) Accessed(filePath string) {
+	if janitor == nil {
+		return
+	}
+	janitor.mutex.Lock()
+	defer janitor.mutex.Unlock()
+	if known, exists := janitor.entries[filePath]; exists {
+		known.accessedAt = time.Now()
+	}
+}
+
+// records a write of size bytes to the file at filePath and wakes up eviction
+// when the cache is over its limit
+func (janitor *Janitor) Written(filePath string, size int64) {
+	if janitor == nil {
+		return
+	}
+	janitor.mutex.Lock()
+	if known, exists := janitor.entries[filePath]; exists {
+		janitor.usage -= known.size
+	}
+	janitor.entries[filePath] = &entry{size: size, accessedAt: time.Now()}
+	janitor.usage += size
+	overLimit := janitor.usage > janitor.maxBytes
+	janitor.mutex.Unlock()
+
+	if overLimit {
+		select {
+		case janitor.evict <- struct{}{}:
+		default:
+			// eviction is already pending
+		}
+	}
+}
+
+// returns the bytes used by the cached files
+func (janitor *Janitor) Usage() int64 {
+	janitor.mutex.Lock()
+	defer janitor.mutex.Unlock()
+	return janitor.usage
+}
+
+// deletes the least recently accessed files until the cache is below its low
+// water mark, when it is over its limit. Returns the number of deleted files.
+// The victims are chosen under the lock and deleted after releasing it, so
+// requests are not blocked by the deletions.
+func (janitor *Janitor) Evict() int {
+	victims, chosenAt := janitor.chooseVictims()
+	deleted := 0
+	for _, filePath := range victims {
+		// A file written again after it was chosen is indexed anew and kept
+		if info, err := os.Stat(filePath); err == nil && info.ModTime().After(chosenAt) {
+			continue
+		}
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			// The next scan indexes the file again
+			log.Printf("Error evicting %s: %v", filePath, err)
+			continue
+		}
+		janitor.removeEmptyDirs(filepath.Dir(filePath))
+		deleted++
+	}
+	if len(victims) > 0 {
+		log.Printf("Evicted %d files from the disk cache, %d bytes in use", deleted, janitor.Usage())
+	}
+	return deleted
+}
+
+// removes the least recently accessed files from the index until the usage is
+// below the low water mark and returns their paths
+func (janitor *Janitor) chooseVictims() ([]string, time.Time) {
+	janitor.mutex.Lock()
+	defer janitor.mutex.Unlock()
+	chosenAt := time.Now()
+	if janitor.usage <= janitor.maxBytes {
+		return nil, chosenAt
+	}
+
+	filePaths := make([]string, 0, len(janitor.entries))
+	for filePath := range janitor.entries {
+		filePaths = append(filePaths, filePath)
+	}
+	sort.Slice(filePaths, func(i, j int) bool {
+		return janitor.entries[filePaths[i]].accessedAt.Before(janitor.entries[filePaths[j]].accessedAt)
+	})
+
+	target := int64(float64(janitor.maxBytes) * lowWaterMark)
+	var victims []string
+	for _, filePath := range filePaths {
+		if janitor.usage <= target {
+			break
+		}
+		janitor.usage -= janitor.entries[filePath].size
+		delete(janitor.entries, filePath)
+		victims = append(victims, filePath)
+	}
+	return victims, chosenAt
+}
+
+// removes dir and its parents while they are empty, up to the directory of
+// the janitor containing them. A writer may create a file in a directory
+// between its MkdirAll and this removal, WriteFile retries in that case.
+func (janitor *Janitor) removeEmptyDirs(dir string) {
+	for _, root := range janitor.dirs {
+		root = filepath.Clean(root)
+		if !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+			continue
+		}
+		for dir != root {
+			if os.Remove(dir) != nil {
+				return
+			}
+			dir = filepath.Dir(dir)
+		}
+		return
+	}
+}
+
+// writes data to filePath, creating its parent directories. Eviction removes
+// directories once they are empty, possibly between their creation and the
+// write, so the write is retried after the directories were created again.
+func WriteFile(filePath string, data []byte, perm os.FileMode) error {
+	const attempts = 3
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if err = os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return fmt.Errorf("failed to create directories: %w", err)
+		}
+		if err = os.WriteFile(filePath, data, perm); !os.IsNotExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package diskcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, filePath string, size int, modTime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filePath, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	images := filepath.Join(t.TempDir(), "images")
+	originals := filepath.Join(t.TempDir(), "originals")
+	now := time.Now()
+	writeFile(t, filepath.Join(images, "a", "fit", "a.webp"), 100, now)
+	writeFile(t, filepath.Join(images, "b", "fit", "b.webp"), 50, now)
+	writeFile(t, filepath.Join(originals, "a", "original"), 200, now)
+
+	janitor := NewJanitor(1000, images, originals, filepath.Join(t.TempDir(), "missing"))
+	if err := janitor.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if got := janitor.Usage(); got != 350 {
+		t.Errorf("Usage() = %d, want 350", got)
+	}
+
+	if err := os.Remove(filepath.Join(images, "b", "fit", "b.webp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := janitor.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if got := janitor.Usage(); got != 300 {
+		t.Errorf("Usage() after removal = %d, want 300", got)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	dir := t.TempDir()
+	oldest := filepath.Join(dir, "a", "fit", "a.webp")
+	accessed := filepath.Join(dir, "b", "fit", "b.webp")
+	newest := filepath.Join(dir, "c", "fit", "c.webp")
+	now := time.Now()
+	writeFile(t, oldest, 300, now.Add(-3*time.Hour))
+	writeFile(t, accessed, 300, now.Add(-2*time.Hour))
+	writeFile(t, newest, 300, now.Add(-time.Hour))
+
+	janitor := NewJanitor(1000, dir)
+	if err := janitor.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if got := janitor.Evict(); got != 0 {
+		t.Errorf("Evict() under the limit = %d, want 0", got)
+	}
+
+	janitor.Accessed(accessed)
+	janitor.Written(filepath.Join(dir, "d", "fit", "d.webp"), 400)
+	if got := janitor.Evict(); got != 2 {
+		t.Errorf("Evict() = %d, want 2", got)
+	}
+	if got := janitor.Usage(); got != 700 {
+		t.Errorf("Usage() = %d, want 700", got)
+	}
+	for _, filePath := range []string{oldest, newest} {
+		if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+			t.Errorf("%s was not evicted", filePath)
+		}
+	}
+	if _, err := os.Stat(accessed); err != nil {
+		t.Errorf("recently accessed %s was evicted", accessed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Errorf("empty directories of evicted files were kept")
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("cache directory was removed")
+	}
+}
+
+func TestNilJanitor(t *testing.T) {
+	var janitor *Janitor
+	janitor.Accessed("a")
+	janitor.Written("a", 100)
+}
+
+func TestEvictKeepsRewrittenFiles(t *testing.T) {
+	dir := t.TempDir()
+	rewritten := filepath.Join(dir, "a", "fit", "a.webp")
+	writeFile(t, rewritten, 600, time.Now().Add(-time.Hour))
+
+	janitor := NewJanitor(1000, dir)
+	if err := janitor.Scan(); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	janitor.Written(filepath.Join(dir, "b", "fit", "b.webp"), 600)
+	// A write landing after the victims were chosen has a later modification time
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(rewritten, future, future); err != nil {
+		t.Fatal(err)
+	}
+	janitor.Evict()
+	if _, err := os.Stat(rewritten); err != nil {
+		t.Errorf("rewritten %s was evicted", rewritten)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "a", "fit", "a.webp")
+	if err := WriteFile(filePath, []byte("data"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil || string(data) != "data" {
+		t.Errorf("ReadFile() = %q, %v, want data", data, err)
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/transformations"
+)
+
+const (
+	maxBatchVariants  = 50
+	maxBatchBodyBytes = 1 << 20
+)
+
+// batchRequest is one source and the /proxy parameters of each variant to generate
+type batchRequest struct {
+	Img      string              `json:"img"`
+	Variants []map[string]string `json:"variants"`
+}
+
+type batchVariant struct {
+	URL string `json:"url"`
+	Key string `json:"key"`
+}
+
+type batchResponse struct {
+	Variants []batchVariant `json:"variants"`
+}
+
+// generates several variants of one source from a single fetch and decode. The
+// POST body is a batchRequest, the response lists the /proxy URL and cache key
+// of every variant in request order.
+func BatchHandler(cache ImageProxyRequestHandler, baseURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveBatch(w, r, cache, baseURL)
+	}
+}
+
+func serveBatch(w http.ResponseWriter, r *http.Request, cache store, baseURL string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var batch batchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodyBytes)).Decode(&batch); err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			http.Error(w, fmt.Sprintf("Batch request is larger than %d bytes", maxBatchBodyBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Invalid batch request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if batch.Img == "" || len(batch.Variants) == 0 {
+		http.Error(w, "A batch needs an img and at least one variant", http.StatusBadRequest)
+		return
+	}
+	if len(batch.Variants) > maxBatchVariants {
+		http.Error(w, fmt.Sprintf("A batch can have at most %d variants", maxBatchVariants), http.StatusBadRequest)
+		return
+	}
+
+	// Validate every variant before doing any work
+	variants := make([]transformations.Options, len(batch.Variants))
+	response := batchResponse{Variants: make([]batchVariant, len(batch.Variants))}
+	for i, parameters := range batch.Variants {
+		query := url.Values{}
+		for name, value := range parameters {
+			query.Set(name, value)
+		}
+		query.Set("img", batch.Img)
+
+		options, err := parseOptions(batch.Img, query.Get, nil)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Issue parsing options of variant %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+		variants[i] = options
+		response.Variants[i].URL = baseURL + "/proxy?" + query.Encode()
+	}
+
+	original, err := cache.fetch(batch.Img)
+	if err != nil {
+		log.Printf("Error downloading image: %v", err)
+		http.Error(w, fmt.Sprintf("Issue getting image: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	keys, err := generateVariants(cache, batch.Img, original, variants)
+	if err != nil {
+		log.Printf("Error generating variants: %v", err)
+		http.Error(w, fmt.Sprintf("Error generating variants: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for i, key := range keys {
+		response.Variants[i].Key = key
+	}
+
+	responseData, err := encodeJSON(response)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(responseData); err != nil {
+		log.Printf("Failed to write response: %v\n", err)
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/originals"
+)
+
+func TestServeBatch(t *testing.T) {
+	const imgPath = "https://example.com/a.png"
+	variants := func(count int) string {
+		parameters := make([]string, count)
+		for i := range parameters {
+			parameters[i] = fmt.Sprintf(`{"width":"%d"}`, i+1)
+		}
+		return strings.Join(parameters, ",")
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		body         string
+		wantStatus   int
+		wantVariants int
+	}{
+		{
+			name:         "Variants",
+			method:       http.MethodPost,
+			body:         `{"img":"` + imgPath + `","variants":[{"width":"20"},{"width":"40","quality":"80"}]}`,
+			wantStatus:   http.StatusOK,
+			wantVariants: 2,
+		},
+		{
+			name:         "Maximum variants",
+			method:       http.MethodPost,
+			body:         `{"img":"` + imgPath + `","variants":[` + variants(maxBatchVariants) + `]}`,
+			wantStatus:   http.StatusOK,
+			wantVariants: maxBatchVariants,
+		},
+		{
+			name:       "Too many variants",
+			method:     http.MethodPost,
+			body:       `{"img":"` + imgPath + `","variants":[` + variants(maxBatchVariants+1) + `]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Body too large",
+			method:     http.MethodPost,
+			body:       `{"img":"` + imgPath + `","variants":[{"width":"20"}],"padding":"` + strings.Repeat("a", maxBatchBodyBytes) + `"}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "Malformed body",
+			method:     http.MethodPost,
+			body:       `{"img":`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "No variants",
+			method:     http.MethodPost,
+			body:       `{"img":"` + imgPath + `","variants":[]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Invalid variant",
+			method:     http.MethodPost,
+			body:       `{"img":"` + imgPath + `","variants":[{"width":"20"},{"mode":"stretch"}]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Unknown original",
+			method:     http.MethodPost,
+			body:       `{"img":"https://example.com/missing.png","variants":[{"width":"20"}]}`,
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "GET",
+			method:     http.MethodGet,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newMemoryStore()
+			cache.originals[imgPath] = &originals.Original{Data: pngData(t, 80, 60)}
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(tt.method, "/batch", strings.NewReader(tt.body))
+			serveBatch(recorder, request, cache, "https://cdn.example.com")
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %.200s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if len(cache.files) != 0 {
+					t.Errorf("a rejected batch stored %d files", len(cache.files))
+				}
+				return
+			}
+			var response batchResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if len(response.Variants) != tt.wantVariants {
+				t.Fatalf("got %d variants, want %d", len(response.Variants), tt.wantVariants)
+			}
+			for _, variant := range response.Variants {
+				if !strings.HasPrefix(variant.URL, "https://cdn.example.com/proxy?") {
+					t.Errorf("url %s does not start with the base url", variant.URL)
+				}
+				if _, err := cache.read(variant.Key); err != nil {
+					t.Errorf("variant %s was not stored", variant.Key)
+				}
+			}
+		})
+	}
+}
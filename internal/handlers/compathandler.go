@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/compat"
+)
+
+// serves imgix-style requests (e.g. /imgix/products/a.jpg?w=300&fit=crop) mounted
+// under prefix, resolving image paths against origin
+func ImgixHandler(proxy http.HandlerFunc, prefix string, origin string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		imgPath := strings.TrimPrefix(r.URL.Path, prefix)
+		values, err := compat.FromImgix(origin, imgPath, r.URL.Query())
+		if err != nil {
+			log.Printf("Issue translating imgix request: %v", err)
+			http.Error(w, fmt.Sprintf("Issue translating imgix request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		proxyRequest := r.Clone(r.Context())
+		proxyRequest.URL.RawQuery = values.Encode()
+		proxy(w, proxyRequest)
+	}
+}
+
+// serves Thumbor-style requests (e.g. /thumbor/unsafe/300x200/smart/example.com/a.jpg)
+// mounted under prefix. With a security key only signed urls are served.
+func ThumborHandler(proxy http.HandlerFunc, prefix string, origin string, securityKey string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		thumborPath := strings.TrimPrefix(r.URL.EscapedPath(), prefix)
+		values, err := compat.FromThumbor(origin, securityKey, thumborPath)
+		if err != nil {
+			log.Printf("Issue translating thumbor request: %v", err)
+			http.Error(w, fmt.Sprintf("Issue translating thumbor request: %v", err), http.StatusNotFound)
+			return
+		}
+
+		proxyRequest := r.Clone(r.Context())
+		proxyRequest.URL.RawQuery = values.Encode()
+		proxy(w, proxyRequest)
+	}
+}
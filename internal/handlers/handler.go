@@ -1,8 +1,60 @@
 package handlers
 
-import "net/http"
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/originals"
+	"github.com/StrongerSoftworks/image-proxy/internal/transformations"
+)
 
 type ImageProxyRequestHandler interface {
 	Init()
 	Handler(w http.ResponseWriter, r *http.Request)
+	store
+}
+
+// store is where a request handler keeps generated files, the local disk or S3.
+// Keys are relative to the cache directory or bucket.
+type store interface {
+	// derivativeKey is the key of imgPath transformed with options
+	derivativeKey(imgPath string, options *transformations.Options) string
+	// metadataKey is the key of a file describing the original imgPath, next to its derivatives
+	metadataKey(imgPath string, name string) string
+	read(key string) ([]byte, error)
+	write(key string, data []byte) error
+	// fetch downloads an original, through the originals cache when it is enabled
+	fetch(imgPath string) (*originals.Original, error)
+	// purge deletes the derivatives, metadata and cached original of imgPath, or
+	// of every source starting with imgPath when prefix is set
+	purge(imgPath string, prefix bool) (int, error)
+	// revalidationInterval is how long stored files are served before their
+	// original is checked for changes, zero when revalidation is disabled
+	revalidationInterval() time.Duration
+}
+
+// originals are cached when ORIGINALS_CACHE is true
+func originalsCacheEnabled() bool {
+	return os.Getenv("ORIGINALS_CACHE") == "true"
+}
+
+// parses the options of a request for imgPath with the /proxy defaults
+func parseOptions(imgPath string, query func(string) string, header func(string) string) (transformations.Options, error) {
+	format, err := transformations.FormatFromPath(imgPath)
+	if err != nil {
+		return transformations.Options{}, err
+	}
+	return parseFormatOptions(format, query, header)
+}
+
+// parses the options of a request for a source in format with the /proxy defaults
+func parseFormatOptions(format string, query func(string) string, header func(string) string) (transformations.Options, error) {
+	options := transformations.Options{
+		Quality: 100,
+		Mode:    "fit",
+		Format:  format,
+	}
+	err := transformations.ParseQuery(query, header, &options)
+	return options, err
 }
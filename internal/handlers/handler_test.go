@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/imgpath"
+	"github.com/StrongerSoftworks/image-proxy/internal/originals"
+	"github.com/StrongerSoftworks/image-proxy/internal/transformations"
+)
+
+// memoryStore keeps files in memory and serves the originals it was given
+type memoryStore struct {
+	mutex     sync.Mutex
+	files     map[string][]byte
+	originals map[string]*originals.Original
+	// purged maps the purged sources to their prefix flag
+	purged map[string]bool
+	// interval is the revalidation interval, zero disables revalidation
+	interval time.Duration
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{files: map[string][]byte{}, originals: map[string]*originals.Original{}}
+}
+
+func (cache *memoryStore) derivativeKey(imgPath string, options *transformations.Options) string {
+	return imgpath.MakeFilePath(imgPath, options)
+}
+
+func (cache *memoryStore) metadataKey(imgPath string, name string) string {
+	return imgpath.MakeMetadataPath(imgPath, name)
+}
+
+func (cache *memoryStore) read(key string) ([]byte, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	data, exists := cache.files[key]
+	if !exists {
+		return nil, os.ErrNotExist
+	}
+	return data, nil
+}
+
+func (cache *memoryStore) write(key string, data []byte) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.files[key] = data
+	return nil
+}
+
+func (cache *memoryStore) fetch(imgPath string) (*originals.Original, error) {
+	original, exists := cache.originals[imgPath]
+	if !exists {
+		return nil, fmt.Errorf("no original of %s", imgPath)
+	}
+	return original, nil
+}
+
+func (cache *memoryStore) purge(imgPath string, prefix bool) (int, error) {
+	if cache.purged == nil {
+		cache.purged = map[string]bool{}
+	}
+	cache.purged[imgPath] = prefix
+	return 1, nil
+}
+
+func (cache *memoryStore) revalidationInterval() time.Duration {
+	return cache.interval
+}
+
+// encodes a width x height png
+func pngData(t *testing.T, width int, height int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// enables presets-only mode with a single preset until the test ends
+func enablePresetsOnly(t *testing.T) {
+	t.Helper()
+	// Cleanups run last in first out, so the settings are reloaded after the
+	// environment variables are restored
+	t.Cleanup(func() {
+		if err := transformations.LoadSettings(); err != nil {
+			t.Error(err)
+		}
+	})
+	presetsFile := filepath.Join(t.TempDir(), "presets.json")
+	if err := os.WriteFile(presetsFile, []byte(`{"thumb": {"width": "20"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PRESETS_FILE", presetsFile)
+	t.Setenv("PRESETS_ONLY", "true")
+	if err := transformations.LoadSettings(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serves an original whose current version has etag, answering conditional
+// requests for it with 304
+func newUpstream(t *testing.T, etag string) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(pngData(t, 80, 60))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// stores a metadata file as it was cached with validators at checkedAt
+func storeMetadata(t *testing.T, cache *memoryStore, key string, data string, validators originals.Validators, checkedAt time.Time) {
+	t.Helper()
+	recordData, err := json.Marshal(sourceRecord{Validators: validators, CheckedAt: checkedAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.files[key] = []byte(data)
+	cache.files[sourceRecordKey(key)] = recordData
+}
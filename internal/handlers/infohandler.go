@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/imginfo"
+)
+
+// serves the JSON metadata of the original image in the img query parameter,
+// cached next to its derivatives and revalidated like them
+func InfoHandler(cache ImageProxyRequestHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveInfo(w, r, cache)
+	}
+}
+
+func serveInfo(w http.ResponseWriter, r *http.Request, cache store) {
+	imgPath := r.URL.Query().Get("img")
+	if imgPath == "" {
+		http.Error(w, "Missing img parameter", http.StatusBadRequest)
+		return
+	}
+
+	key := cache.metadataKey(imgPath, "info.json")
+	if infoData, err := cache.read(key); err == nil && !needsRegeneration(cache, imgPath, key, cache.revalidationInterval()) {
+		writeJSON(w, infoData)
+		return
+	}
+
+	original, err := cache.fetch(imgPath)
+	if err != nil {
+		log.Printf("Error downloading image: %v", err)
+		http.Error(w, fmt.Sprintf("Issue getting image: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	info, err := imginfo.Describe(original.Data)
+	if err != nil {
+		log.Printf("Error reading image info: %v", err)
+		http.Error(w, fmt.Sprintf("Error reading image info: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+	infoData, err := json.Marshal(info)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding image info: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := cache.write(key, infoData); err != nil {
+		log.Printf("Error saving image info: %v", err)
+		http.Error(w, fmt.Sprintf("Error saving image info: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := recordSource(cache, key, original.Validators); err != nil {
+		log.Printf("Error saving source record: %v", err)
+	}
+	writeJSON(w, infoData)
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/imginfo"
+	"github.com/StrongerSoftworks/image-proxy/internal/originals"
+)
+
+func TestServeInfo(t *testing.T) {
+	const currentETag = `"v2"`
+	upstream := newUpstream(t, currentETag)
+	imgPath := upstream.URL + "/a.png"
+	const cachedInfo = `{"width":10,"height":10,"format":"png"}`
+
+	tests := []struct {
+		name     string
+		img      string
+		original []byte
+		// cachedAt is when the cached info was last checked, zero when nothing is cached
+		cachedAt   time.Time
+		cachedETag string
+		interval   time.Duration
+		wantStatus int
+		// wantWidth is the width in the response, 10 for the cached info
+		wantWidth int
+	}{
+		{
+			name:       "Info",
+			img:        imgPath,
+			original:   pngData(t, 80, 60),
+			wantStatus: http.StatusOK,
+			wantWidth:  80,
+		},
+		{
+			name:       "Cached info",
+			img:        imgPath,
+			original:   pngData(t, 80, 60),
+			cachedAt:   time.Now().Add(-2 * time.Hour),
+			cachedETag: `"v1"`,
+			wantStatus: http.StatusOK,
+			wantWidth:  10,
+		},
+		{
+			name:       "Cached info checked within the interval",
+			img:        imgPath,
+			original:   pngData(t, 80, 60),
+			cachedAt:   time.Now(),
+			cachedETag: `"v1"`,
+			interval:   time.Hour,
+			wantStatus: http.StatusOK,
+			wantWidth:  10,
+		},
+		{
+			name:       "Cached info of an unchanged original",
+			img:        imgPath,
+			original:   pngData(t, 80, 60),
+			cachedAt:   time.Now().Add(-2 * time.Hour),
+			cachedETag: currentETag,
+			interval:   time.Hour,
+			wantStatus: http.StatusOK,
+			wantWidth:  10,
+		},
+		{
+			name:       "Cached info of a changed original",
+			img:        imgPath,
+			original:   pngData(t, 80, 60),
+			cachedAt:   time.Now().Add(-2 * time.Hour),
+			cachedETag: `"v1"`,
+			interval:   time.Hour,
+			wantStatus: http.StatusOK,
+			wantWidth:  80,
+		},
+		{
+			name:       "Missing img",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Unknown original",
+			img:        upstream.URL + "/missing.png",
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "Not an image",
+			img:        imgPath,
+			original:   []byte("not an image"),
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newMemoryStore()
+			cache.interval = tt.interval
+			if tt.original != nil {
+				cache.originals[imgPath] = &originals.Original{Data: tt.original, Validators: originals.Validators{ETag: currentETag}}
+			}
+			key := cache.metadataKey(imgPath, "info.json")
+			if !tt.cachedAt.IsZero() {
+				storeMetadata(t, cache, key, cachedInfo, originals.Validators{ETag: tt.cachedETag}, tt.cachedAt)
+			}
+
+			recorder := httptest.NewRecorder()
+			serveInfo(recorder, httptest.NewRequest(http.MethodGet, "/info?img="+url.QueryEscape(tt.img), nil), cache)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var info imginfo.Info
+			if err := json.Unmarshal(recorder.Body.Bytes(), &info); err != nil {
+				t.Fatalf("invalid info: %v", err)
+			}
+			if info.Width != tt.wantWidth {
+				t.Errorf("width = %d, want %d", info.Width, tt.wantWidth)
+			}
+			if string(cache.files[key]) != recorder.Body.String() {
+				t.Errorf("cached info %s differs from the response", cache.files[key])
+			}
+			var record sourceRecord
+			if err := json.Unmarshal(cache.files[sourceRecordKey(key)], &record); err != nil {
+				t.Fatalf("invalid source record: %v", err)
+			}
+			if tt.wantWidth != 10 && record.ETag != currentETag {
+				t.Errorf("source record ETag = %s, want %s", record.ETag, currentETag)
+			}
+		})
+	}
+}
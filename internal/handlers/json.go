@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/imghttp"
+)
+
+// marshals v without escaping &, < and >, which keeps URLs and markup readable
+func encodeJSON(v any) ([]byte, error) {
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(data.Bytes(), []byte("\n")), nil
+}
+
+// writes cacheable JSON, e.g. metadata of an original
+func writeJSON(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", imghttp.CacheControl)
+	if _, err := w.Write(data); err != nil {
+		log.Printf("Failed to write response: %v\n", err)
+	}
+}
@@ -7,33 +7,47 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
+	"time"
 
+	"github.com/StrongerSoftworks/image-proxy/internal/diskcache"
 	"github.com/StrongerSoftworks/image-proxy/internal/imghttp"
 	"github.com/StrongerSoftworks/image-proxy/internal/imgpath"
+	"github.com/StrongerSoftworks/image-proxy/internal/originals"
 	"github.com/StrongerSoftworks/image-proxy/internal/transformations"
 )
 
-type LocalRequestHandler struct{}
+type LocalRequestHandler struct {
+	originals          *originals.Cache
+	revalidateInterval time.Duration
+	janitor            *diskcache.Janitor
+}
 
 func NewLocalRequestHandler() *LocalRequestHandler {
-	handler := LocalRequestHandler{}
+	handler := LocalRequestHandler{originals: originals.New(nil)}
 	return &handler
 }
 
 func (handler *LocalRequestHandler) Init() {
 	log.Println("Images will be saved to " + imageBasePath())
+	if maxBytes := loadCacheMaxBytes(); maxBytes > 0 {
+		handler.janitor = diskcache.NewJanitor(maxBytes, imageBasePath(), originalsBasePath())
+		if err := handler.janitor.Start(); err != nil {
+			log.Fatalf("Error scanning disk cache: %v", err)
+		}
+	}
+	if originalsCacheEnabled() {
+		log.Println("Originals will be cached in " + originalsBasePath())
+		handler.originals = originals.New(janitorStore{originals.DirStore(originalsBasePath()), handler.janitor})
+	}
+	handler.revalidateInterval = LoadRevalidateInterval()
 }
 
 func (handler *LocalRequestHandler) Handler(w http.ResponseWriter, r *http.Request) {
 
 	// Extract query parameters
-	imgPath := r.URL.Query().Get("img")
-	widthQuery := r.URL.Query().Get("width")
-	heightQuery := r.URL.Query().Get("height")
-	aspectRatioQuery := r.URL.Query().Get("ratio")
-	modeQuery := r.URL.Query().Get("mode")
-	formatQuery := r.URL.Query().Get("format")
-	qualityQuery := r.URL.Query().Get("quality")
+	query := r.URL.Query()
+	imgPath := query.Get("img")
 
 	format, err := transformations.FormatFromPath(imgPath)
 	if err != nil {
@@ -48,33 +62,44 @@ func (handler *LocalRequestHandler) Handler(w http.ResponseWriter, r *http.Reque
 		Mode:    "fit",
 		Format:  format,
 	}
-	err = transformations.ParseOptions(widthQuery, heightQuery, formatQuery, modeQuery, qualityQuery, aspectRatioQuery, &options)
+	err = transformations.ParseQuery(query.Get, r.Header.Get, &options)
 	if err != nil {
 		log.Printf("Issue parsing options: %v", err)
 		http.Error(w, fmt.Sprintf("Issue parsing options: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	// Check if file exists
-	filePath := filepath.Join(imageBasePath(), imgpath.MakeFilePath(imgPath, &options))
-	if _, err := os.Stat(filePath); err == nil {
+	// Check if file exists and its original has not changed
+	key := handler.derivativeKey(imgPath, &options)
+	filePath := filepath.Join(imageBasePath(), key)
+	if _, err := os.Stat(filePath); err == nil && !needsRegeneration(handler, imgPath, key, handler.revalidateInterval) {
 		imgData, err := os.ReadFile(filePath)
-		if err != nil {
+		if err == nil {
+			handler.janitor.Accessed(filePath)
+			writeResponse(w, options, imgData)
+			return
+		}
+		// The file may have been evicted since the stat, it is then regenerated
+		if !os.IsNotExist(err) {
 			log.Printf("Error getting image: %v", err)
 			http.Error(w, fmt.Sprintf("Error getting image: %v", err), http.StatusInternalServerError)
 			return
 		}
-		writeResponse(w, options, imgData)
-		return
 	}
 
 	// Get the image from source
-	img, _, err := imghttp.GetImage(imgPath)
+	original, err := handler.fetch(imgPath)
 	if err != nil {
 		log.Printf("Error downloading image: %v", err)
 		http.Error(w, fmt.Sprintf("Issue getting image: %v", err), http.StatusInternalServerError)
 		return
 	}
+	img, _, err := imghttp.DecodeImage(original.Data)
+	if err != nil {
+		log.Printf("Error decoding image: %v", err)
+		http.Error(w, fmt.Sprintf("Issue getting image: %v", err), http.StatusInternalServerError)
+		return
+	}
 
 	// Apply transformations
 	imgData, err := transformations.TransformImage(img, &options)
@@ -85,22 +110,106 @@ func (handler *LocalRequestHandler) Handler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Save transformed image
-	err = writeFileWithDirs(filePath, imgData.Bytes(), 0644)
+	err = diskcache.WriteFile(filePath, imgData.Bytes(), 0644)
 	if err != nil {
 		log.Printf("Error saving image: %v", err)
 		http.Error(w, fmt.Sprintf("Error saving image: %v", err), http.StatusInternalServerError)
 		return
 	}
+	handler.janitor.Written(filePath, int64(imgData.Len()))
+	if err := recordSource(handler, key, original.Validators); err != nil {
+		log.Printf("Error saving source record: %v", err)
+	}
 
 	// Return the transformed image
 	writeResponse(w, options, imgData.Bytes())
 }
 
+func (handler *LocalRequestHandler) fetch(imgPath string) (*originals.Original, error) {
+	return handler.originals.Get(imgPath)
+}
+
+func (handler *LocalRequestHandler) purge(imgPath string, prefix bool) (int, error) {
+	deleted, err := imgpath.Purge(imageBasePath(), imgPath, prefix)
+	if err != nil {
+		return deleted, err
+	}
+	originalsDeleted, err := imgpath.Purge(originalsBasePath(), imgPath, prefix)
+	if handler.janitor != nil {
+		if err := handler.janitor.Scan(); err != nil {
+			log.Printf("Error scanning disk cache: %v", err)
+		}
+	}
+	return deleted + originalsDeleted, err
+}
+
+func (handler *LocalRequestHandler) revalidationInterval() time.Duration {
+	return handler.revalidateInterval
+}
+
+func (handler *LocalRequestHandler) derivativeKey(imgPath string, options *transformations.Options) string {
+	return imgpath.MakeFilePath(imgPath, options)
+}
+
+func (handler *LocalRequestHandler) metadataKey(imgPath string, name string) string {
+	return imgpath.MakeMetadataPath(imgPath, name)
+}
+
+func (handler *LocalRequestHandler) read(key string) ([]byte, error) {
+	filePath := filepath.Join(imageBasePath(), key)
+	data, err := os.ReadFile(filePath)
+	if err == nil {
+		handler.janitor.Accessed(filePath)
+	}
+	return data, err
+}
+
+func (handler *LocalRequestHandler) write(key string, data []byte) error {
+	filePath := filepath.Join(imageBasePath(), key)
+	if err := diskcache.WriteFile(filePath, data, 0644); err != nil {
+		return err
+	}
+	handler.janitor.Written(filePath, int64(len(data)))
+	return nil
+}
+
+// janitorStore keeps the disk cache janitor informed of the originals read
+// from and written to a DirStore
+type janitorStore struct {
+	originals.DirStore
+	janitor *diskcache.Janitor
+}
+
+func (tracked janitorStore) Read(imgPath string) (*originals.Original, error) {
+	original, err := tracked.DirStore.Read(imgPath)
+	if err == nil {
+		for _, filePath := range tracked.Files(imgPath) {
+			tracked.janitor.Accessed(filePath)
+		}
+	}
+	return original, err
+}
+
+func (tracked janitorStore) Write(imgPath string, original *originals.Original) error {
+	if err := tracked.DirStore.Write(imgPath, original); err != nil {
+		return err
+	}
+	for _, filePath := range tracked.Files(imgPath) {
+		if info, err := os.Stat(filePath); err == nil {
+			tracked.janitor.Written(filePath, info.Size())
+		}
+	}
+	return nil
+}
+
 func writeResponse(w http.ResponseWriter, options transformations.Options, imgData []byte) {
 	headers := imghttp.ImageHeaders(options.Format, imgData)
 	for key, value := range headers {
 		w.Header().Set(key, value)
 	}
+	for key, value := range transformations.ResponseHeaders(&options) {
+		w.Header().Set(key, value)
+	}
 
 	w.WriteHeader(http.StatusOK) // Optional, as 200 is the default status code
 	if _, err := w.Write(imgData); err != nil {
@@ -108,21 +217,30 @@ func writeResponse(w http.ResponseWriter, options transformations.Options, imgDa
 	}
 }
 
-func writeFileWithDirs(imgPath string, data []byte, perm os.FileMode) error {
-	// Create all parent directories with 0755 permissions
-	dir := filepath.Dir(imgPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directories: %w", err)
+// reads CACHE_MAX_BYTES, the size limit of the disk cache. Zero disables eviction.
+func loadCacheMaxBytes() int64 {
+	value := os.Getenv("CACHE_MAX_BYTES")
+	if value == "" {
+		return 0
 	}
-
-	// Write the file
-	if err := os.WriteFile(imgPath, data, perm); err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+	maxBytes, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || maxBytes < 0 {
+		log.Fatalf("invalid CACHE_MAX_BYTES: %s", value)
 	}
+	return maxBytes
+}
 
-	return nil
+func cacheDir() string {
+	if dir := os.Getenv("CACHE_DIR"); dir != "" {
+		return dir
+	}
+	return path.Join(os.TempDir(), "image-proxy")
 }
 
 func imageBasePath() string {
-	return path.Join(os.TempDir(), "image-proxy", "images")
+	return path.Join(cacheDir(), "images")
+}
+
+func originalsBasePath() string {
+	return path.Join(cacheDir(), "originals")
 }
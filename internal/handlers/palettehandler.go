@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/imghttp"
+	"github.com/StrongerSoftworks/image-proxy/internal/transformations"
+)
+
+const defaultPaletteColors = 5
+
+type paletteResponse struct {
+	Dominant string                         `json:"dominant"`
+	Palette  []transformations.PaletteColor `json:"palette"`
+}
+
+// serves the dominant color and a palette of the original image in the img
+// query parameter, colors sets the palette size. Cached next to the derivatives
+// and revalidated like them.
+func PaletteHandler(cache ImageProxyRequestHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		servePalette(w, r, cache)
+	}
+}
+
+func servePalette(w http.ResponseWriter, r *http.Request, cache store) {
+	query := r.URL.Query()
+	imgPath := query.Get("img")
+	if imgPath == "" {
+		http.Error(w, "Missing img parameter", http.StatusBadRequest)
+		return
+	}
+	colors := defaultPaletteColors
+	if colorsQuery := query.Get("colors"); colorsQuery != "" {
+		var err error
+		colors, err = strconv.Atoi(colorsQuery)
+		if err != nil || colors < 1 || colors > transformations.MaxPaletteColors {
+			http.Error(w, fmt.Sprintf("Invalid colors: %s. must be between 1 and %d", colorsQuery, transformations.MaxPaletteColors),
+				http.StatusBadRequest)
+			return
+		}
+	}
+
+	key := cache.metadataKey(imgPath, fmt.Sprintf("palette-%d.json", colors))
+	if paletteData, err := cache.read(key); err == nil && !needsRegeneration(cache, imgPath, key, cache.revalidationInterval()) {
+		writeJSON(w, paletteData)
+		return
+	}
+
+	original, err := cache.fetch(imgPath)
+	if err != nil {
+		log.Printf("Error downloading image: %v", err)
+		http.Error(w, fmt.Sprintf("Issue getting image: %v", err), http.StatusInternalServerError)
+		return
+	}
+	img, _, err := imghttp.DecodeImage(original.Data)
+	if err != nil {
+		log.Printf("Error decoding image: %v", err)
+		http.Error(w, fmt.Sprintf("Issue getting image: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	palette := transformations.Palette(img, colors)
+	response := paletteResponse{Palette: palette}
+	if len(palette) > 0 {
+		response.Dominant = palette[0].Color
+	}
+	paletteData, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding palette: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := cache.write(key, paletteData); err != nil {
+		log.Printf("Error saving palette: %v", err)
+		http.Error(w, fmt.Sprintf("Error saving palette: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := recordSource(cache, key, original.Validators); err != nil {
+		log.Printf("Error saving source record: %v", err)
+	}
+	writeJSON(w, paletteData)
+}
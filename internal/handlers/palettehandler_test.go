@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/originals"
+	"github.com/StrongerSoftworks/image-proxy/internal/transformations"
+)
+
+func TestServePalette(t *testing.T) {
+	const currentETag = `"v2"`
+	upstream := newUpstream(t, currentETag)
+	imgPath := upstream.URL + "/a.png"
+	const cachedDominant = "#000000"
+	cachedPalette := `{"dominant":"` + cachedDominant + `","palette":[{"color":"` + cachedDominant + `","share":1}]}`
+
+	tests := []struct {
+		name     string
+		img      string
+		colors   string
+		original []byte
+		// cachedAt is when the cached palette was last checked, zero when nothing is cached
+		cachedAt   time.Time
+		cachedETag string
+		interval   time.Duration
+		wantStatus int
+		// wantColors is the palette size the response is cached under
+		wantColors int
+		wantCached bool
+	}{
+		{
+			name:       "Default colors",
+			img:        imgPath,
+			original:   pngData(t, 80, 60),
+			wantStatus: http.StatusOK,
+			wantColors: defaultPaletteColors,
+		},
+		{
+			name:       "Maximum colors",
+			img:        imgPath,
+			colors:     fmt.Sprint(transformations.MaxPaletteColors),
+			original:   pngData(t, 80, 60),
+			wantStatus: http.StatusOK,
+			wantColors: transformations.MaxPaletteColors,
+		},
+		{
+			name:       "Cached palette",
+			img:        imgPath,
+			original:   pngData(t, 80, 60),
+			cachedAt:   time.Now().Add(-2 * time.Hour),
+			cachedETag: `"v1"`,
+			wantStatus: http.StatusOK,
+			wantColors: defaultPaletteColors,
+			wantCached: true,
+		},
+		{
+			name:       "Cached palette of an unchanged original",
+			img:        imgPath,
+			original:   pngData(t, 80, 60),
+			cachedAt:   time.Now().Add(-2 * time.Hour),
+			cachedETag: currentETag,
+			interval:   time.Hour,
+			wantStatus: http.StatusOK,
+			wantColors: defaultPaletteColors,
+			wantCached: true,
+		},
+		{
+			name:       "Cached palette of a changed original",
+			img:        imgPath,
+			original:   pngData(t, 80, 60),
+			cachedAt:   time.Now().Add(-2 * time.Hour),
+			cachedETag: `"v1"`,
+			interval:   time.Hour,
+			wantStatus: http.StatusOK,
+			wantColors: defaultPaletteColors,
+		},
+		{
+			name:       "No colors",
+			img:        imgPath,
+			colors:     "0",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Too many colors",
+			img:        imgPath,
+			colors:     fmt.Sprint(transformations.MaxPaletteColors + 1),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Invalid colors",
+			img:        imgPath,
+			colors:     "many",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Missing img",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Unknown original",
+			img:        upstream.URL + "/missing.png",
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "Not an image",
+			img:        imgPath,
+			original:   []byte("not an image"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newMemoryStore()
+			cache.interval = tt.interval
+			if tt.original != nil {
+				cache.originals[imgPath] = &originals.Original{Data: tt.original, Validators: originals.Validators{ETag: currentETag}}
+			}
+			key := cache.metadataKey(imgPath, fmt.Sprintf("palette-%d.json", tt.wantColors))
+			if !tt.cachedAt.IsZero() {
+				storeMetadata(t, cache, key, cachedPalette, originals.Validators{ETag: tt.cachedETag}, tt.cachedAt)
+			}
+
+			query := url.Values{}
+			if tt.img != "" {
+				query.Set("img", tt.img)
+			}
+			if tt.colors != "" {
+				query.Set("colors", tt.colors)
+			}
+			recorder := httptest.NewRecorder()
+			servePalette(recorder, httptest.NewRequest(http.MethodGet, "/palette?"+query.Encode(), nil), cache)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if len(cache.files) != 0 {
+					t.Errorf("a failed request stored %d files", len(cache.files))
+				}
+				return
+			}
+			var response paletteResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("invalid palette: %v", err)
+			}
+			if len(response.Palette) == 0 || len(response.Palette) > tt.wantColors {
+				t.Errorf("got %d colors, want 1 to %d", len(response.Palette), tt.wantColors)
+			} else if response.Dominant != response.Palette[0].Color {
+				t.Errorf("dominant = %s, want the first palette color %s", response.Dominant, response.Palette[0].Color)
+			}
+			if cached := response.Dominant == cachedDominant; cached != tt.wantCached {
+				t.Errorf("served the cached palette: %v, want %v", cached, tt.wantCached)
+			}
+			if string(cache.files[key]) != recorder.Body.String() {
+				t.Errorf("cached palette %s differs from the response", cache.files[key])
+			}
+			if _, err := cache.read(sourceRecordKey(key)); err != nil {
+				t.Errorf("source of %s was not recorded", key)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/imgurl"
+)
+
+// serves the path-encoded API (e.g. /w:300,h:200/plain/https://example.com/a.jpg)
+// by translating the path into /proxy query parameters for the proxy handler
+func PathHandler(proxy http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		values, err := imgurl.ParsePath(r.URL.EscapedPath(), r.URL.RawQuery)
+		if err != nil {
+			log.Printf("Issue parsing image path: %v", err)
+			http.Error(w, fmt.Sprintf("Issue parsing image path: %v", err), http.StatusNotFound)
+			return
+		}
+
+		proxyRequest := r.Clone(r.Context())
+		proxyRequest.URL.RawQuery = values.Encode()
+		proxy(w, proxyRequest)
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type purgeResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// deletes every cached file of the source in the url query parameter, or of
+// every source starting with it when prefix=true. Requests must be POSTs with
+// an "Authorization: Bearer <token>" header.
+func PurgeHandler(cache ImageProxyRequestHandler, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		servePurge(w, r, cache, token)
+	}
+}
+
+func servePurge(w http.ResponseWriter, r *http.Request, cache store, token string) {
+	bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || !found || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	imgPath := query.Get("url")
+	if imgPath == "" {
+		http.Error(w, "Missing url parameter", http.StatusBadRequest)
+		return
+	}
+	prefix := false
+	if prefixQuery := query.Get("prefix"); prefixQuery != "" {
+		var err error
+		if prefix, err = strconv.ParseBool(prefixQuery); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid prefix: %s", prefixQuery), http.StatusBadRequest)
+			return
+		}
+	}
+
+	deleted, err := purgeStore(cache, imgPath, prefix)
+	if err != nil {
+		log.Printf("Error purging %s: %v", imgPath, err)
+		http.Error(w, fmt.Sprintf("Error purging: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	responseData, err := encodeJSON(purgeResponse{Deleted: deleted})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(responseData); err != nil {
+		log.Printf("Failed to write response: %v\n", err)
+	}
+}
+
+// deletes every cached file of imgPath from the handler's storage, or of every
+// source starting with imgPath when prefix is set. Returns the number of deleted files.
+func Purge(handler ImageProxyRequestHandler, imgPath string, prefix bool) (int, error) {
+	return purgeStore(handler, imgPath, prefix)
+}
+
+func purgeStore(cache store, imgPath string, prefix bool) (int, error) {
+	// An empty prefix would match every source
+	if imgPath == "" {
+		return 0, fmt.Errorf("missing source url")
+	}
+	deleted, err := cache.purge(imgPath, prefix)
+	log.Printf("Purged %d files of %s (prefix: %t)", deleted, imgPath, prefix)
+	return deleted, err
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestServePurge(t *testing.T) {
+	const token = "secret"
+	const imgPath = "https://example.com/a.png"
+
+	tests := []struct {
+		name          string
+		method        string
+		query         string
+		authorization string
+		token         string
+		wantStatus    int
+		wantPurged    map[string]bool
+	}{
+		{
+			name:          "Purge",
+			method:        http.MethodPost,
+			query:         "url=" + imgPath,
+			authorization: "Bearer " + token,
+			token:         token,
+			wantStatus:    http.StatusOK,
+			wantPurged:    map[string]bool{imgPath: false},
+		},
+		{
+			name:          "Prefix",
+			method:        http.MethodPost,
+			query:         "url=https://example.com/&prefix=true",
+			authorization: "Bearer " + token,
+			token:         token,
+			wantStatus:    http.StatusOK,
+			wantPurged:    map[string]bool{"https://example.com/": true},
+		},
+		{
+			name:       "Missing token",
+			method:     http.MethodPost,
+			query:      "url=" + imgPath,
+			token:      token,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "Wrong token",
+			method:        http.MethodPost,
+			query:         "url=" + imgPath,
+			authorization: "Bearer other",
+			token:         token,
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:          "Token without the Bearer scheme",
+			method:        http.MethodPost,
+			query:         "url=" + imgPath,
+			authorization: token,
+			token:         token,
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:          "Purging disabled",
+			method:        http.MethodPost,
+			query:         "url=" + imgPath,
+			authorization: "Bearer ",
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:          "GET",
+			method:        http.MethodGet,
+			query:         "url=" + imgPath,
+			authorization: "Bearer " + token,
+			token:         token,
+			wantStatus:    http.StatusMethodNotAllowed,
+		},
+		{
+			name:          "Missing url",
+			method:        http.MethodPost,
+			authorization: "Bearer " + token,
+			token:         token,
+			wantStatus:    http.StatusBadRequest,
+		},
+		{
+			name:          "Invalid prefix",
+			method:        http.MethodPost,
+			query:         "url=" + imgPath + "&prefix=maybe",
+			authorization: "Bearer " + token,
+			token:         token,
+			wantStatus:    http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newMemoryStore()
+			request := httptest.NewRequest(tt.method, "/purge?"+tt.query, nil)
+			if tt.authorization != "" {
+				request.Header.Set("Authorization", tt.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			servePurge(recorder, request, cache, tt.token)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			if !reflect.DeepEqual(cache.purged, tt.wantPurged) {
+				t.Errorf("purged %v, want %v", cache.purged, tt.wantPurged)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var response purgeResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if response.Deleted != 1 {
+				t.Errorf("deleted = %d, want 1", response.Deleted)
+			}
+		})
+	}
+}
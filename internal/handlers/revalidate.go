@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/originals"
+)
+
+// sourceRecord is stored next to a derivative and identifies the version of
+// the original it was generated from
+type sourceRecord struct {
+	originals.Validators
+	// CheckedAt is when the original was last confirmed unchanged
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+func sourceRecordKey(key string) string {
+	return key + ".source.json"
+}
+
+// reads REVALIDATE_INTERVAL, how long a derivative is served before its
+// original is checked for changes. Zero disables revalidation.
+func LoadRevalidateInterval() time.Duration {
+	value := os.Getenv("REVALIDATE_INTERVAL")
+	if value == "" {
+		return 0
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < 0 {
+		log.Fatalf("invalid REVALIDATE_INTERVAL: %s", value)
+	}
+	return interval
+}
+
+// records the version of the original the derivative at key was generated
+// from in the handler's storage
+func RecordSource(handler ImageProxyRequestHandler, key string, validators originals.Validators) error {
+	return recordSource(handler, key, validators)
+}
+
+func recordSource(cache store, key string, validators originals.Validators) error {
+	recordData, err := json.Marshal(sourceRecord{Validators: validators, CheckedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	if err := cache.write(sourceRecordKey(key), recordData); err != nil {
+		return fmt.Errorf("failed to record source: %w", err)
+	}
+	return nil
+}
+
+// reports whether the derivative at key in the handler's storage must be
+// regenerated because its original changed, see needsRegeneration
+func NeedsRegeneration(handler ImageProxyRequestHandler, imgPath string, key string, interval time.Duration) bool {
+	return needsRegeneration(handler, imgPath, key, interval)
+}
+
+// reports whether the stored derivative at key must be regenerated because its
+// original changed. The original is checked at most once per interval, and the
+// derivative is kept when the check fails.
+func needsRegeneration(cache store, imgPath string, key string, interval time.Duration) bool {
+	if interval <= 0 {
+		return false
+	}
+
+	// Derivatives stored before revalidation was enabled have no record and are checked now
+	var record sourceRecord
+	if recordData, err := cache.read(sourceRecordKey(key)); err == nil {
+		if err := json.Unmarshal(recordData, &record); err != nil {
+			log.Printf("Invalid source record of %s: %v", key, err)
+		}
+	}
+	if time.Since(record.CheckedAt) < interval {
+		return false
+	}
+
+	changed, err := originals.Changed(imgPath, record.Validators)
+	if err != nil {
+		log.Printf("Keeping %s: %v", key, err)
+		return false
+	}
+	if changed {
+		return true
+	}
+	if err := recordSource(cache, key, record.Validators); err != nil {
+		log.Printf("Error saving source record of %s: %v", key, err)
+	}
+	return false
+}
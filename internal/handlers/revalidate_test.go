@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/originals"
+)
+
+func TestNeedsRegeneration(t *testing.T) {
+	const key = "a.png/fit/20/0/0/100/a.png"
+	const currentETag = `"v2"`
+	requests := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/broken.png" {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", currentETag)
+		if r.Header.Get("If-None-Match") == currentETag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("image"))
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name         string
+		path         string
+		interval     time.Duration
+		record       *sourceRecord
+		want         bool
+		wantRequests int
+		// wantRecheck is set when the record must be refreshed after the check
+		wantRecheck bool
+	}{
+		{
+			name:     "Revalidation disabled",
+			path:     "/a.png",
+			interval: 0,
+			want:     false,
+		},
+		{
+			name:     "No source record",
+			path:     "/a.png",
+			interval: time.Hour,
+			want:     true,
+		},
+		{
+			name:     "Checked within the interval",
+			path:     "/a.png",
+			interval: time.Hour,
+			record:   &sourceRecord{Validators: originals.Validators{ETag: `"v1"`}, CheckedAt: time.Now()},
+			want:     false,
+		},
+		{
+			name:         "Unchanged original",
+			path:         "/a.png",
+			interval:     time.Hour,
+			record:       &sourceRecord{Validators: originals.Validators{ETag: currentETag}, CheckedAt: time.Now().Add(-2 * time.Hour)},
+			want:         false,
+			wantRequests: 1,
+			wantRecheck:  true,
+		},
+		{
+			name:         "Changed original",
+			path:         "/a.png",
+			interval:     time.Hour,
+			record:       &sourceRecord{Validators: originals.Validators{ETag: `"v1"`}, CheckedAt: time.Now().Add(-2 * time.Hour)},
+			want:         true,
+			wantRequests: 1,
+		},
+		{
+			name:         "Failed check keeps the derivative",
+			path:         "/broken.png",
+			interval:     time.Hour,
+			record:       &sourceRecord{Validators: originals.Validators{ETag: `"v1"`}, CheckedAt: time.Now().Add(-2 * time.Hour)},
+			want:         false,
+			wantRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests = 0
+			cache := newMemoryStore()
+			if tt.record != nil {
+				recordData, err := json.Marshal(tt.record)
+				if err != nil {
+					t.Fatal(err)
+				}
+				cache.files[sourceRecordKey(key)] = recordData
+			}
+
+			if got := needsRegeneration(cache, upstream.URL+tt.path, key, tt.interval); got != tt.want {
+				t.Errorf("needsRegeneration() = %v, want %v", got, tt.want)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("upstream got %d requests, want %d", requests, tt.wantRequests)
+			}
+			if tt.wantRecheck {
+				var record sourceRecord
+				if err := json.Unmarshal(cache.files[sourceRecordKey(key)], &record); err != nil {
+					t.Fatal(err)
+				}
+				if time.Since(record.CheckedAt) > time.Minute || record.ETag != currentETag {
+					t.Errorf("source record %+v was not refreshed", record)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordSource(t *testing.T) {
+	const key = "a.png/fit/20/0/0/100/a.png"
+	cache := newMemoryStore()
+	validators := originals.Validators{ETag: `"v1"`, LastModified: "Mon, 19 Oct 2026 10:00:00 GMT"}
+	if err := recordSource(cache, key, validators); err != nil {
+		t.Fatalf("recordSource() error = %v", err)
+	}
+
+	var record sourceRecord
+	if err := json.Unmarshal(cache.files[key+".source.json"], &record); err != nil {
+		t.Fatalf("invalid source record: %v", err)
+	}
+	if record.Validators != validators {
+		t.Errorf("recorded validators = %+v, want %+v", record.Validators, validators)
+	}
+	if time.Since(record.CheckedAt) > time.Minute {
+		t.Errorf("CheckedAt = %v, want now", record.CheckedAt)
+	}
+	if needsRegeneration(cache, "http://127.0.0.1:0/a.png", key, time.Hour) {
+		t.Errorf("a freshly recorded derivative needs regeneration")
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/StrongerSoftworks/image-proxy/internal/imghttp"
 	"github.com/StrongerSoftworks/image-proxy/internal/imgs3"
+	"github.com/StrongerSoftworks/image-proxy/internal/originals"
 	"github.com/StrongerSoftworks/image-proxy/internal/transformations"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -15,13 +17,15 @@ import (
 
 type S3RequestHanlder struct {
 	ImageProxyRequestHandler
-	s3Client   *s3.Client
-	uploader   *manager.Uploader
-	bucketName string
+	s3Client           *s3.Client
+	uploader           *manager.Uploader
+	bucketName         string
+	originals          *originals.Cache
+	revalidateInterval time.Duration
 }
 
 func NewS3RequestHanlder() *S3RequestHanlder {
-	handler := S3RequestHanlder{}
+	handler := S3RequestHanlder{originals: originals.New(nil)}
 	return &handler
 }
 
@@ -31,16 +35,18 @@ func (handler *S3RequestHanlder) Init() {
 	handler.s3Client = imgs3.InitAWS(context.Background())
 	handler.uploader = manager.NewUploader(handler.s3Client)
 	handler.bucketName = bucketName
+	if originalsCacheEnabled() {
+		store := &imgs3.OriginalStore{Client: handler.s3Client, Uploader: handler.uploader, Bucket: bucketName,
+			Prefix: imgs3.GetOriginalsPrefix()}
+		log.Println("Originals will be cached in " + bucketName + "/" + store.Prefix)
+		handler.originals = originals.New(store)
+	}
+	handler.revalidateInterval = LoadRevalidateInterval()
 }
 
 func (handler *S3RequestHanlder) Handler(w http.ResponseWriter, r *http.Request) {
-	imgPath := r.URL.Query().Get("img")
-	widthQuery := r.URL.Query().Get("width")
-	heightQuery := r.URL.Query().Get("height")
-	aspectRatioQuery := r.URL.Query().Get("ratio")
-	modeQuery := r.URL.Query().Get("mode")
-	formatQuery := r.URL.Query().Get("format")
-	qualityQuery := r.URL.Query().Get("quality")
+	query := r.URL.Query()
+	imgPath := query.Get("img")
 
 	format, err := transformations.FormatFromPath(imgPath)
 	if err != nil {
@@ -53,30 +59,38 @@ func (handler *S3RequestHanlder) Handler(w http.ResponseWriter, r *http.Request)
 		Mode:    "fit",
 		Format:  format,
 	}
-	err = transformations.ParseOptions(widthQuery, heightQuery, formatQuery, modeQuery, qualityQuery, aspectRatioQuery, &options)
+	err = transformations.ParseQuery(query.Get, r.Header.Get, &options)
 	if err != nil {
 		http.Error(w, "Invalid transformation options", http.StatusBadRequest)
 		return
 	}
 
-	s3Key := imgs3.MakeBucketFileKey(imgPath, &options)
+	s3Key := handler.derivativeKey(imgPath, &options)
+	for key, value := range transformations.ResponseHeaders(&options) {
+		w.Header().Set(key, value)
+	}
 
 	// Check if image exists in S3
 	_, err = handler.s3Client.HeadObject(context.TODO(), &s3.HeadObjectInput{
 		Bucket: &handler.bucketName,
 		Key:    &s3Key,
 	})
-	if err == nil {
+	if err == nil && !needsRegeneration(handler, imgPath, s3Key, handler.revalidateInterval) {
 		redirectURL := fmt.Sprintf("https://%s.s3.amazonaws.com/%s", handler.bucketName, s3Key)
 		http.Redirect(w, r, redirectURL, http.StatusFound)
 		return
 	}
 
-	img, _, err := imghttp.GetImage(imgPath)
+	original, err := handler.fetch(imgPath)
 	if err != nil {
 		http.Error(w, "Error downloading image", http.StatusInternalServerError)
 		return
 	}
+	img, _, err := imghttp.DecodeImage(original.Data)
+	if err != nil {
+		http.Error(w, "Error decoding image", http.StatusInternalServerError)
+		return
+	}
 
 	imgData, err := transformations.TransformImage(img, &options)
 	if err != nil {
@@ -89,7 +103,38 @@ func (handler *S3RequestHanlder) Handler(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Error uploading to S3", http.StatusInternalServerError)
 		return
 	}
+	if err := recordSource(handler, s3Key, original.Validators); err != nil {
+		log.Printf("Error saving source record: %v", err)
+	}
 
 	redirectURL := fmt.Sprintf("https://%s.s3.amazonaws.com/%s", handler.bucketName, s3Key)
 	http.Redirect(w, r, redirectURL, http.StatusFound)
 }
+
+func (handler *S3RequestHanlder) fetch(imgPath string) (*originals.Original, error) {
+	return handler.originals.Get(imgPath)
+}
+
+func (handler *S3RequestHanlder) purge(imgPath string, prefix bool) (int, error) {
+	return imgs3.Purge(context.TODO(), handler.s3Client, handler.bucketName, imgPath, prefix)
+}
+
+func (handler *S3RequestHanlder) revalidationInterval() time.Duration {
+	return handler.revalidateInterval
+}
+
+func (handler *S3RequestHanlder) derivativeKey(imgPath string, options *transformations.Options) string {
+	return imgs3.MakeBucketFileKey(imgPath, options)
+}
+
+func (handler *S3RequestHanlder) metadataKey(imgPath string, name string) string {
+	return imgs3.MakeBucketMetadataKey(imgPath, name)
+}
+
+func (handler *S3RequestHanlder) read(key string) ([]byte, error) {
+	return imgs3.GetImage(context.TODO(), handler.s3Client, handler.bucketName, key)
+}
+
+func (handler *S3RequestHanlder) write(key string, data []byte) error {
+	return imgs3.UploadImage(context.TODO(), handler.uploader, handler.bucketName, key, data)
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/transformations"
+)
+
+// SrcsetConfig is the width ladder and formats of srcset manifests
+type SrcsetConfig struct {
+	Widths []int
+	// Formats are offered before the format of the source, which is always the fallback
+	Formats []string
+	// BaseURL is prepended to the /proxy URLs, empty for relative URLs
+	BaseURL string
+}
+
+// reads the srcset configuration from SRCSET_WIDTHS, SRCSET_FORMATS and PUBLIC_URL
+func LoadSrcsetConfig() (SrcsetConfig, error) {
+	config := SrcsetConfig{
+		Widths:  []int{320, 640, 960, 1280, 1920},
+		Formats: []string{"avif", "webp"},
+		BaseURL: strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/"),
+	}
+
+	if value := os.Getenv("SRCSET_WIDTHS"); value != "" {
+		config.Widths = nil
+		for _, widthValue := range strings.Split(value, ",") {
+			width, err := strconv.Atoi(strings.TrimSpace(widthValue))
+			if err != nil || width <= 0 {
+				return config, fmt.Errorf("invalid SRCSET_WIDTHS: %s", value)
+			}
+			config.Widths = append(config.Widths, width)
+		}
+	}
+
+	if value, exists := os.LookupEnv("SRCSET_FORMATS"); exists {
+		config.Formats = nil
+		for _, format := range strings.Split(value, ",") {
+			format = strings.TrimSpace(format)
+			if format == "" {
+				continue
+			}
+			if !transformations.ValidFormat(format) {
+				return config, fmt.Errorf("invalid SRCSET_FORMATS: %s", value)
+			}
+			config.Formats = append(config.Formats, format)
+		}
+	}
+
+	return config, nil
+}
+
+// Parameters of the srcset endpoint that are not transformation options
+var srcsetParameters = []string{"width", "format", "dpr", "sizes", "markup", "pregenerate"}
+
+type srcsetManifest struct {
+	Sources []srcsetSource `json:"sources"`
+	Markup  string         `json:"markup,omitempty"`
+}
+
+type srcsetSource struct {
+	Format string      `json:"format"`
+	Type   string      `json:"type"`
+	Srcset string      `json:"srcset"`
+	URLs   []srcsetURL `json:"urls"`
+}
+
+type srcsetURL struct {
+	Width int    `json:"width"`
+	URL   string `json:"url"`
+	// Key is where the variant was stored when it was pregenerated
+	Key string `json:"key,omitempty"`
+}
+
+// serves a manifest of /proxy URLs of the img query parameter for every width
+// and format in config, with the other parameters applied to each. markup=true
+// adds <picture> markup using the sizes parameter and pregenerate=true stores
+// every variant before responding. Manifests are rejected in presets-only mode.
+func SrcsetHandler(cache ImageProxyRequestHandler, config SrcsetConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveSrcset(w, r, cache, config)
+	}
+}
+
+func serveSrcset(w http.ResponseWriter, r *http.Request, cache store, config SrcsetConfig) {
+	query := r.URL.Query()
+	imgPath := query.Get("img")
+	if imgPath == "" {
+		http.Error(w, "Missing img parameter", http.StatusBadRequest)
+		return
+	}
+	// A preset replaces the width and format of every entry, so the ladder
+	// would list the same image under every width
+	if transformations.PresetsOnly() {
+		http.Error(w, "srcset manifests are not available when PRESETS_ONLY is set", http.StatusBadRequest)
+		return
+	}
+	if query.Get("height") != "" {
+		http.Error(w, "height is not supported by srcset manifests, use ratio", http.StatusBadRequest)
+		return
+	}
+	sourceFormat, err := transformations.FormatFromPath(imgPath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting format from file URL: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	baseQuery := url.Values{}
+	for name, values := range query {
+		baseQuery[name] = values
+	}
+	for _, name := range srcsetParameters {
+		baseQuery.Del(name)
+	}
+
+	manifest := srcsetManifest{}
+	var variants []transformations.Options
+	for _, format := range srcsetFormats(config.Formats, sourceFormat) {
+		source := srcsetSource{Format: format, Type: mimeType(format)}
+		var candidates []string
+		for _, width := range config.Widths {
+			variantQuery := url.Values{}
+			for name, values := range baseQuery {
+				variantQuery[name] = values
+			}
+			variantQuery.Set("width", strconv.Itoa(width))
+			variantQuery.Set("format", format)
+
+			options, err := parseOptions(imgPath, variantQuery.Get, nil)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Issue parsing options: %v", err), http.StatusBadRequest)
+				return
+			}
+			variants = append(variants, options)
+
+			variantURL := config.BaseURL + "/proxy?" + variantQuery.Encode()
+			source.URLs = append(source.URLs, srcsetURL{Width: width, URL: variantURL})
+			candidates = append(candidates, fmt.Sprintf("%s %dw", variantURL, width))
+		}
+		source.Srcset = strings.Join(candidates, ", ")
+		manifest.Sources = append(manifest.Sources, source)
+	}
+
+	if query.Get("pregenerate") == "true" {
+		original, err := cache.fetch(imgPath)
+		if err != nil {
+			log.Printf("Error downloading image: %v", err)
+			http.Error(w, fmt.Sprintf("Issue getting image: %v", err), http.StatusInternalServerError)
+			return
+		}
+		keys, err := generateVariants(cache, imgPath, original, variants)
+		if err != nil {
+			log.Printf("Error generating variants: %v", err)
+			http.Error(w, fmt.Sprintf("Error generating variants: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i := range manifest.Sources {
+			for j := range manifest.Sources[i].URLs {
+				manifest.Sources[i].URLs[j].Key = keys[i*len(config.Widths)+j]
+			}
+		}
+	}
+
+	if query.Get("markup") == "true" {
+		sizes := query.Get("sizes")
+		if sizes == "" {
+			sizes = "100vw"
+		}
+		manifest.Markup = pictureMarkup(manifest.Sources, sizes)
+	}
+
+	manifestData, err := encodeJSON(manifest)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding manifest: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, manifestData)
+}
+
+// the configured formats followed by the source format, which every browser can show
+func srcsetFormats(formats []string, sourceFormat string) []string {
+	var result []string
+	for _, format := range formats {
+		if format != sourceFormat {
+			result = append(result, format)
+		}
+	}
+	return append(result, sourceFormat)
+}
+
+func mimeType(format string) string {
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	return "image/" + format
+}
+
+// builds a <picture> with a <source> per format and the last source as the <img>
+func pictureMarkup(sources []srcsetSource, sizes string) string {
+	var markup strings.Builder
+	markup.WriteString("<picture>")
+	for _, source := range sources[:len(sources)-1] {
+		fmt.Fprintf(&markup, `<source type="%s" srcset="%s" sizes="%s">`,
+			source.Type, html.EscapeString(source.Srcset), html.EscapeString(sizes))
+	}
+	fallback := sources[len(sources)-1]
+	largest := fallback.URLs[len(fallback.URLs)-1].URL
+	fmt.Fprintf(&markup, `<img src="%s" srcset="%s" sizes="%s">`,
+		html.EscapeString(largest), html.EscapeString(fallback.Srcset), html.EscapeString(sizes))
+	markup.WriteString("</picture>")
+	return markup.String()
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/originals"
+)
+
+func TestServeSrcset(t *testing.T) {
+	const imgPath = "https://example.com/a.png"
+	config := SrcsetConfig{Widths: []int{20, 40}, Formats: []string{"webp"}, BaseURL: "https://cdn.example.com"}
+
+	tests := []struct {
+		name        string
+		query       string
+		config      SrcsetConfig
+		presetsOnly bool
+		wantStatus  int
+		wantFormats []string
+		// wantParameter is expected in every url
+		wantParameter string
+		wantMarkup    bool
+		wantKeys      bool
+	}{
+		{
+			name:          "Manifest",
+			query:         "img=" + imgPath + "&quality=80",
+			config:        config,
+			wantStatus:    http.StatusOK,
+			wantFormats:   []string{"webp", "png"},
+			wantParameter: "quality=80",
+		},
+		{
+			name:        "Markup",
+			query:       "img=" + imgPath + "&markup=true&sizes=50vw",
+			config:      config,
+			wantStatus:  http.StatusOK,
+			wantFormats: []string{"webp", "png"},
+			wantMarkup:  true,
+		},
+		{
+			name:        "Pregenerate",
+			query:       "img=" + imgPath + "&pregenerate=true",
+			config:      SrcsetConfig{Widths: []int{20, 40}},
+			wantStatus:  http.StatusOK,
+			wantFormats: []string{"png"},
+			wantKeys:    true,
+		},
+		{
+			name:        "Presets only",
+			query:       "img=" + imgPath + "&preset=thumb",
+			config:      config,
+			presetsOnly: true,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:       "Missing img",
+			query:      "width=20",
+			config:     config,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Height",
+			query:      "img=" + imgPath + "&height=20",
+			config:     config,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Invalid option",
+			query:      "img=" + imgPath + "&quality=101",
+			config:     config,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.presetsOnly {
+				enablePresetsOnly(t)
+			}
+			cache := newMemoryStore()
+			cache.originals[imgPath] = &originals.Original{Data: pngData(t, 80, 60)}
+			recorder := httptest.NewRecorder()
+			serveSrcset(recorder, httptest.NewRequest(http.MethodGet, "/srcset?"+tt.query, nil), cache, tt.config)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var manifest srcsetManifest
+			if err := json.Unmarshal(recorder.Body.Bytes(), &manifest); err != nil {
+				t.Fatalf("invalid manifest: %v", err)
+			}
+			if len(manifest.Sources) != len(tt.wantFormats) {
+				t.Fatalf("got %d sources, want %d", len(manifest.Sources), len(tt.wantFormats))
+			}
+			for i, source := range manifest.Sources {
+				if source.Format != tt.wantFormats[i] {
+					t.Errorf("source %d format = %s, want %s", i, source.Format, tt.wantFormats[i])
+				}
+				if len(source.URLs) != len(tt.config.Widths) {
+					t.Fatalf("source %d has %d urls, want %d", i, len(source.URLs), len(tt.config.Widths))
+				}
+				for j, sourceURL := range source.URLs {
+					if !strings.HasPrefix(sourceURL.URL, tt.config.BaseURL+"/proxy?") {
+						t.Errorf("url %s does not start with the base url", sourceURL.URL)
+					}
+					if !strings.Contains(source.Srcset, fmt.Sprintf("%s %dw", sourceURL.URL, sourceURL.Width)) {
+						t.Errorf("srcset %s does not list %s", source.Srcset, sourceURL.URL)
+					}
+					if !strings.Contains(sourceURL.URL, tt.wantParameter) {
+						t.Errorf("url %s lacks %s", sourceURL.URL, tt.wantParameter)
+					}
+					if sourceURL.Width != tt.config.Widths[j] {
+						t.Errorf("url width = %d, want %d", sourceURL.Width, tt.config.Widths[j])
+					}
+					if tt.wantKeys {
+						if _, err := cache.read(sourceURL.Key); err != nil {
+							t.Errorf("variant %s was not stored", sourceURL.Key)
+						}
+						if _, err := cache.read(sourceRecordKey(sourceURL.Key)); err != nil {
+							t.Errorf("source of %s was not recorded", sourceURL.Key)
+						}
+					} else if sourceURL.Key != "" {
+						t.Errorf("url key = %s, want none", sourceURL.Key)
+					}
+				}
+			}
+			if hasMarkup := strings.HasPrefix(manifest.Markup, "<picture>"); hasMarkup != tt.wantMarkup {
+				t.Errorf("markup = %q, want markup %v", manifest.Markup, tt.wantMarkup)
+			}
+			if tt.wantMarkup && !strings.Contains(manifest.Markup, `<source type="image/webp"`) {
+				t.Errorf("markup %s lacks the webp source", manifest.Markup)
+			}
+		})
+	}
+}
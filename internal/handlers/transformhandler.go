@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/transformations"
+)
+
+// Uploads with more pixels are rejected before decoding, a small file can
+// decode to a huge image
+const maxUploadPixels = 50_000_000
+
+// transforms an uploaded image with the same query parameters as /proxy. The
+// POST body is either the raw image or a multipart form with an image field, at
+// most maxBytes long. With store=true the result is cached under a hash of the
+// upload and its key returned in the X-Cache-Key header.
+func TransformHandler(cache ImageProxyRequestHandler, maxBytes int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveTransform(w, r, cache, maxBytes)
+	}
+}
+
+func serveTransform(w http.ResponseWriter, r *http.Request, cache store, maxBytes int64) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	imgData, err := readUpload(w, r, maxBytes)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			http.Error(w, fmt.Sprintf("Upload is larger than %d bytes", maxBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Invalid upload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	config, sourceFormat, err := image.DecodeConfig(bytes.NewReader(imgData))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid image: %v", err), http.StatusUnsupportedMediaType)
+		return
+	}
+	if config.Width*config.Height > maxUploadPixels {
+		http.Error(w, fmt.Sprintf("Image is larger than %d pixels", maxUploadPixels), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Sources that can not be encoded, e.g. gif, default to png
+	format := sourceFormat
+	if format == "jpeg" {
+		format = "jpg"
+	}
+	if !transformations.ValidFormat(format) {
+		format = "png"
+	}
+	query := r.URL.Query()
+	options, err := parseFormatOptions(format, query.Get, r.Header.Get)
+	if err != nil {
+		log.Printf("Issue parsing options: %v", err)
+		http.Error(w, fmt.Sprintf("Issue parsing options: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	storeResult := query.Get("store") == "true"
+	var key string
+	if storeResult {
+		hash := sha256.Sum256(imgData)
+		key = cache.derivativeKey("uploads/"+hex.EncodeToString(hash[:])+"."+format, &options)
+		w.Header().Set("X-Cache-Key", key)
+		if cached, err := cache.read(key); err == nil {
+			writeResponse(w, options, cached)
+			return
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(imgData))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid image: %v", err), http.StatusUnsupportedMediaType)
+		return
+	}
+	transformed, err := transformations.TransformImage(img, &options)
+	if err != nil {
+		log.Printf("Could not apply transformations to image: %v", err)
+		http.Error(w, fmt.Sprintf("Could not apply transformations to image: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if storeResult {
+		if err := cache.write(key, transformed.Bytes()); err != nil {
+			log.Printf("Error saving image: %v", err)
+			http.Error(w, fmt.Sprintf("Error saving image: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	writeResponse(w, options, transformed.Bytes())
+}
+
+// reads the image from a multipart image field or the raw body
+func readUpload(w http.ResponseWriter, r *http.Request, maxBytes int64) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return io.ReadAll(r.Body)
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("missing image field")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "image" {
+			return io.ReadAll(part)
+		}
+	}
+}
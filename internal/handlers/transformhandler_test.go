@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// a png header declaring a width x height image, enough for image.DecodeConfig
+func pngHeader(t *testing.T, width uint32, height uint32) []byte {
+	t.Helper()
+	data := pngData(t, 1, 1)
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func multipartUpload(t *testing.T, field string, data []byte) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile(field, "a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, writer.FormDataContentType()
+}
+
+func TestServeTransform(t *testing.T) {
+	upload := pngData(t, 80, 60)
+	form, formContentType := multipartUpload(t, "image", upload)
+	otherForm, otherFormContentType := multipartUpload(t, "file", upload)
+	hash := sha256.Sum256(upload)
+
+	tests := []struct {
+		name        string
+		method      string
+		query       string
+		contentType string
+		body        []byte
+		maxBytes    int64
+		wantStatus  int
+		// wantKeyOf is the source of the expected X-Cache-Key, empty when nothing is stored
+		wantKeyOf string
+	}{
+		{
+			name:       "Raw body",
+			method:     http.MethodPost,
+			query:      "width=20",
+			body:       upload,
+			maxBytes:   1 << 20,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:        "Multipart form",
+			method:      http.MethodPost,
+			query:       "width=20",
+			contentType: formContentType,
+			body:        form.Bytes(),
+			maxBytes:    1 << 20,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:       "Store",
+			method:     http.MethodPost,
+			query:      "width=20&store=true",
+			body:       upload,
+			maxBytes:   1 << 20,
+			wantStatus: http.StatusOK,
+			wantKeyOf:  "uploads/" + hex.EncodeToString(hash[:]) + ".png",
+		},
+		{
+			name:       "Upload larger than maxBytes",
+			method:     http.MethodPost,
+			body:       upload,
+			maxBytes:   int64(len(upload)) - 1,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "Image with too many pixels",
+			method:     http.MethodPost,
+			body:       pngHeader(t, 10000, 10000),
+			maxBytes:   1 << 20,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "Not an image",
+			method:     http.MethodPost,
+			body:       []byte("not an image"),
+			maxBytes:   1 << 20,
+			wantStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "Multipart form without image field",
+			method:      http.MethodPost,
+			contentType: otherFormContentType,
+			body:        otherForm.Bytes(),
+			maxBytes:    1 << 20,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:       "Invalid option",
+			method:     http.MethodPost,
+			query:      "quality=101",
+			body:       upload,
+			maxBytes:   1 << 20,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "GET",
+			method:     http.MethodGet,
+			maxBytes:   1 << 20,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newMemoryStore()
+			// The second request of a stored upload is served from the cache
+			for attempt := 0; attempt < 2; attempt++ {
+				request := httptest.NewRequest(tt.method, "/transform?"+tt.query, bytes.NewReader(tt.body))
+				if tt.contentType != "" {
+					request.Header.Set("Content-Type", tt.contentType)
+				}
+				recorder := httptest.NewRecorder()
+				serveTransform(recorder, request, cache, tt.maxBytes)
+
+				if recorder.Code != tt.wantStatus {
+					t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+				}
+				if tt.wantStatus == http.StatusOK && recorder.Header().Get("Content-Type") != "image/png" {
+					t.Errorf("Content-Type = %s, want image/png", recorder.Header().Get("Content-Type"))
+				}
+
+				wantKey := ""
+				if tt.wantKeyOf != "" {
+					query, _ := url.ParseQuery(tt.query)
+					options, err := parseFormatOptions("png", query.Get, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					wantKey = cache.derivativeKey(tt.wantKeyOf, &options)
+				}
+				if got := recorder.Header().Get("X-Cache-Key"); got != wantKey {
+					t.Errorf("X-Cache-Key = %s, want %s", got, wantKey)
+				}
+				wantFiles := 0
+				if wantKey != "" {
+					wantFiles = 1
+				}
+				if len(cache.files) != wantFiles {
+					t.Errorf("stored %d files, want %d", len(cache.files), wantFiles)
+				}
+				if wantKey != "" && !bytes.Equal(cache.files[wantKey], recorder.Body.Bytes()) {
+					t.Errorf("the response differs from the stored image")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/imghttp"
+	"github.com/StrongerSoftworks/image-proxy/internal/originals"
+	"github.com/StrongerSoftworks/image-proxy/internal/transformations"
+)
+
+// transforms an original into every variant in parallel and stores them,
+// returning the keys in the order of variants
+func generateVariants(cache store, imgPath string, original *originals.Original, variants []transformations.Options) ([]string, error) {
+	img, _, err := imghttp.DecodeImage(original.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(variants))
+	errs := make([]error, len(variants))
+
+	// Transformations are CPU bound, more workers than cores only adds memory
+	workers := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for i := range variants {
+		keys[i] = cache.derivativeKey(imgPath, &variants[i])
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+
+			// TransformImage resolves the options in place
+			options := variants[i]
+			imgData, err := transformations.TransformImage(img, &options)
+			if err != nil {
+				errs[i] = fmt.Errorf("variant %d: %w", i, err)
+				return
+			}
+			if err := cache.write(keys[i], imgData.Bytes()); err != nil {
+				errs[i] = fmt.Errorf("variant %d: %w", i, err)
+				return
+			}
+			if err := recordSource(cache, keys[i], original.Validators); err != nil {
+				errs[i] = fmt.Errorf("variant %d: %w", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return keys, errors.Join(errs...)
+}
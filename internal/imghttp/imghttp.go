@@ -1,28 +1,83 @@
 package imghttp
 
 import (
+	"bytes"
 	"fmt"
 	"image"
+	"io"
 	"net/http"
+	"os"
+	"strconv"
 )
 
+// Cache-Control sent with transformed images and metadata, cached for 7 days
+const CacheControl = "public, max-age=604800"
+
+const defaultMaxSourceBytes = 50 << 20
+
+// maxSourceBytes caps the size of downloaded originals, set by LoadSettings
+var maxSourceBytes int64 = defaultMaxSourceBytes
+
+// reads MAX_SOURCE_BYTES, the largest original that is downloaded
+func LoadSettings() error {
+	value := os.Getenv("MAX_SOURCE_BYTES")
+	if value == "" {
+		maxSourceBytes = defaultMaxSourceBytes
+		return nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || parsed <= 0 {
+		return fmt.Errorf("invalid MAX_SOURCE_BYTES: %s", value)
+	}
+	maxSourceBytes = parsed
+	return nil
+}
+
 func GetImage(imgPath string) (image.Image, string, error) {
+	imgData, err := GetImageData(imgPath)
+	if err != nil {
+		return nil, "", err
+	}
+	return DecodeImage(imgData)
+}
+
+func DecodeImage(imgData []byte) (image.Image, string, error) {
+	img, format, err := image.Decode(bytes.NewReader(imgData))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %v", err)
+	}
+	return img, format, err
+}
+
+// downloads the original image without decoding it
+func GetImageData(imgPath string) ([]byte, error) {
 	resp, err := http.Get(imgPath)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to fetch image: %v", err)
+		return nil, fmt.Errorf("failed to fetch image: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("error fetching image: HTTP %d", resp.StatusCode)
+		return nil, fmt.Errorf("error fetching image: HTTP %d", resp.StatusCode)
 	}
 
-	// Decode the image
-	img, format, err := image.Decode(resp.Body)
+	return ReadSource(resp)
+}
+
+// reads the body of a downloaded original, failing instead of buffering more
+// than MAX_SOURCE_BYTES
+func ReadSource(resp *http.Response) ([]byte, error) {
+	if resp.ContentLength > maxSourceBytes {
+		return nil, fmt.Errorf("image of %d bytes exceeds the maximum of %d bytes", resp.ContentLength, maxSourceBytes)
+	}
+	imgData, err := io.ReadAll(io.LimitReader(resp.Body, maxSourceBytes+1))
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to decode image: %v", err)
+		return nil, fmt.Errorf("failed to read image: %v", err)
 	}
-	return img, format, err
+	if int64(len(imgData)) > maxSourceBytes {
+		return nil, fmt.Errorf("image exceeds the maximum of %d bytes", maxSourceBytes)
+	}
+	return imgData, nil
 }
 
 func ContentType(extension string, imgData []byte) string {
@@ -37,6 +92,6 @@ func ContentType(extension string, imgData []byte) string {
 func ImageHeaders(imgFormat string, imgData []byte) map[string]string {
 	return map[string]string{
 		"Content-Type":  ContentType(imgFormat, imgData),
-		"Cache-Control": "public, max-age=604800", // Cache for 7 days
+		"Cache-Control": CacheControl,
 	}
 }
@@ -0,0 +1,46 @@
+package imghttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetImageData(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Streamed responses have no Content-Length to check up front
+		if r.URL.Path == "/chunked.png" {
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		maxBytes int64
+		wantErr  bool
+	}{
+		{name: "Within the maximum", path: "/a.png", maxBytes: 100},
+		{name: "Content-Length over the maximum", path: "/a.png", maxBytes: 99, wantErr: true},
+		{name: "Streamed body over the maximum", path: "/chunked.png", maxBytes: 99, wantErr: true},
+		{name: "Streamed body within the maximum", path: "/chunked.png", maxBytes: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(previous int64) { maxSourceBytes = previous }(maxSourceBytes)
+			maxSourceBytes = tt.maxBytes
+
+			got, err := GetImageData(server.URL + tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetImageData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && string(got) != body {
+				t.Errorf("GetImageData() = %d bytes, want %d", len(got), len(body))
+			}
+		})
+	}
+}
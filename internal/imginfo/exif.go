@@ -0,0 +1,204 @@
+package imginfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+)
+
+// Exif holds the basic EXIF fields of an image
+type Exif struct {
+	Make     string `json:"make,omitempty"`
+	Model    string `json:"model,omitempty"`
+	DateTime string `json:"date_time,omitempty"`
+	// Orientation is 1-8 as defined by EXIF, 0 when not set
+	Orientation int `json:"orientation,omitempty"`
+}
+
+const (
+	tagMake             = 0x010f
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// finds and parses the EXIF block of a jpeg or png, nil when there is none
+func readExif(imgData []byte) *Exif {
+	var tiff []byte
+	switch {
+	case bytes.HasPrefix(imgData, []byte{0xff, 0xd8}):
+		tiff = jpegExif(imgData)
+	case bytes.HasPrefix(imgData, []byte("\x89PNG\r\n\x1a\n")):
+		tiff = pngExif(imgData)
+	}
+	if tiff == nil {
+		return nil
+	}
+	return parseTIFF(tiff)
+}
+
+// returns the TIFF data of the APP1 Exif segment
+func jpegExif(imgData []byte) []byte {
+	offset := 2
+	for offset+4 <= len(imgData) {
+		if imgData[offset] != 0xff {
+			return nil
+		}
+		marker := imgData[offset+1]
+		// Start of scan, the metadata segments come before it
+		if marker == 0xda {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(imgData[offset+2:]))
+		end := offset + 2 + length
+		if length < 2 || end > len(imgData) {
+			return nil
+		}
+		segment := imgData[offset+4 : end]
+		if marker == 0xe1 && bytes.HasPrefix(segment, exifHeader) {
+			return segment[len(exifHeader):]
+		}
+		offset = end
+	}
+	return nil
+}
+
+// returns the TIFF data of the eXIf chunk
+func pngExif(imgData []byte) []byte {
+	offset := 8
+	for offset+12 <= len(imgData) {
+		length := int(binary.BigEndian.Uint32(imgData[offset:]))
+		chunkType := string(imgData[offset+4 : offset+8])
+		end := offset + 12 + length
+		if length < 0 || end > len(imgData) {
+			return nil
+		}
+		if chunkType == "eXIf" {
+			chunk := imgData[offset+8 : offset+8+length]
+			if binary.BigEndian.Uint32(imgData[offset+8+length:]) != crc32.ChecksumIEEE(imgData[offset+4:offset+8+length]) {
+				return nil
+			}
+			return bytes.TrimPrefix(chunk, exifHeader)
+		}
+		if chunkType == "IDAT" {
+			return nil
+		}
+		offset = end
+	}
+	return nil
+}
+
+func parseTIFF(tiff []byte) *Exif {
+	if len(tiff) < 8 {
+		return nil
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return nil
+	}
+
+	exif := &Exif{}
+	exifIFD := 0
+	readIFD(tiff, order, int(order.Uint32(tiff[4:])), func(tag uint16, value ifdValue) {
+		switch tag {
+		case tagMake:
+			exif.Make = value.ascii()
+		case tagModel:
+			exif.Model = value.ascii()
+		case tagDateTime:
+			exif.DateTime = value.ascii()
+		case tagOrientation:
+			if orientation := value.short(); orientation >= 1 && orientation <= 8 {
+				exif.Orientation = orientation
+			}
+		case tagExifIFD:
+			exifIFD = value.long()
+		}
+	})
+	if exifIFD > 0 {
+		// The original capture time is more useful than the last modification
+		readIFD(tiff, order, exifIFD, func(tag uint16, value ifdValue) {
+			if tag == tagDateTimeOriginal {
+				exif.DateTime = value.ascii()
+			}
+		})
+	}
+
+	if *exif == (Exif{}) {
+		return nil
+	}
+	return exif
+}
+
+// ifdValue is one IFD entry, its data either inline or at an offset in the TIFF data
+type ifdValue struct {
+	tiff      []byte
+	order     binary.ByteOrder
+	valueType uint16
+	count     int
+	raw       []byte
+}
+
+func (value ifdValue) ascii() string {
+	if value.valueType != 2 {
+		return ""
+	}
+	data := value.raw
+	if value.count > 4 {
+		offset := int(value.order.Uint32(value.raw))
+		if offset < 0 || offset+value.count > len(value.tiff) {
+			return ""
+		}
+		data = value.tiff[offset : offset+value.count]
+	} else {
+		data = data[:value.count]
+	}
+	return strings.TrimSpace(strings.TrimRight(string(data), "\x00"))
+}
+
+func (value ifdValue) short() int {
+	if value.valueType != 3 {
+		return 0
+	}
+	return int(value.order.Uint16(value.raw))
+}
+
+func (value ifdValue) long() int {
+	if value.valueType != 4 {
+		return 0
+	}
+	return int(value.order.Uint32(value.raw))
+}
+
+func readIFD(tiff []byte, order binary.ByteOrder, offset int, visit func(tag uint16, value ifdValue)) {
+	if offset < 8 || offset+2 > len(tiff) {
+		return
+	}
+	entries := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < entries; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return
+		}
+		visit(order.Uint16(tiff[entry:]), ifdValue{
+			tiff:      tiff,
+			order:     order,
+			valueType: order.Uint16(tiff[entry+2:]),
+			count:     int(order.Uint32(tiff[entry+4:])),
+			raw:       tiff[entry+8 : entry+12],
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package imginfo
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Info describes an original image without fully decoding it
+type Info struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Format string `json:"format"`
+	// Orientation is the EXIF orientation, 1 when the image has none
+	Orientation int   `json:"orientation"`
+	HasAlpha    bool  `json:"has_alpha"`
+	FileSize    int   `json:"file_size"`
+	Exif        *Exif `json:"exif,omitempty"`
+}
+
+// reads the metadata of an encoded image. Formats other than gif, jpeg and png
+// need their decoder registered by the caller.
+func Describe(imgData []byte) (*Info, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(imgData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image config: %v", err)
+	}
+
+	info := &Info{
+		Width:       config.Width,
+		Height:      config.Height,
+		Format:      format,
+		Orientation: 1,
+		HasAlpha:    hasAlpha(config.ColorModel),
+		FileSize:    len(imgData),
+		Exif:        readExif(imgData),
+	}
+	if info.Exif != nil && info.Exif.Orientation != 0 {
+		info.Orientation = info.Exif.Orientation
+	}
+	return info, nil
+}
+
+func hasAlpha(model color.Model) bool {
+	switch model {
+	case color.GrayModel, color.Gray16Model, color.YCbCrModel, color.CMYKModel:
+		return false
+	case color.RGBAModel, color.RGBA64Model, color.NRGBAModel, color.NRGBA64Model,
+		color.AlphaModel, color.Alpha16Model, color.NYCbCrAModel:
+		return true
+	}
+	// Paletted images only have alpha when a palette entry is transparent
+	if palette, ok := model.(color.Palette); ok {
+		for _, entry := range palette {
+			if _, _, _, a := entry.RGBA(); a != 0xffff {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package imginfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+// builds little endian TIFF data with Make, Orientation and an Exif IFD holding DateTimeOriginal
+func testTIFF() []byte {
+	var tiff bytes.Buffer
+	le := binary.LittleEndian
+	write := func(values ...any) {
+		for _, value := range values {
+			binary.Write(&tiff, le, value)
+		}
+	}
+	const ifd0, exifIFD, makeOffset, dateOffset = 8, 50, 68, 74
+
+	write([]byte("II"), uint16(42), uint32(ifd0))
+	// IFD0
+	write(uint16(3))
+	write(uint16(tagMake), uint16(2), uint32(6), uint32(makeOffset))
+	write(uint16(tagOrientation), uint16(3), uint32(1), uint16(6), uint16(0))
+	write(uint16(tagExifIFD), uint16(4), uint32(1), uint32(exifIFD))
+	write(uint32(0))
+	// Exif IFD
+	write(uint16(1))
+	write(uint16(tagDateTimeOriginal), uint16(2), uint32(20), uint32(dateOffset))
+	write(uint32(0))
+	write([]byte("Canon\x00"))
+	write([]byte("2024:05:01 10:20:30\x00"))
+	return tiff.Bytes()
+}
+
+func testJPEG(t *testing.T, tiff []byte) []byte {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 40, 30)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if tiff == nil {
+		return encoded.Bytes()
+	}
+	segment := append(append([]byte{}, exifHeader...), tiff...)
+	app1 := binary.BigEndian.AppendUint16([]byte{0xff, 0xe1}, uint16(len(segment)+2))
+	imgData := append([]byte{}, encoded.Bytes()[:2]...)
+	imgData = append(imgData, app1...)
+	imgData = append(imgData, segment...)
+	return append(imgData, encoded.Bytes()[2:]...)
+}
+
+func TestDescribe(t *testing.T) {
+	jpegWithExif := testJPEG(t, testTIFF())
+	plainJPEG := testJPEG(t, nil)
+
+	var transparentPNG bytes.Buffer
+	if err := png.Encode(&transparentPNG, image.NewNRGBA(image.Rect(0, 0, 8, 4))); err != nil {
+		t.Fatal(err)
+	}
+	var opaquePaletted bytes.Buffer
+	palette := color.Palette{color.Black, color.White}
+	if err := png.Encode(&opaquePaletted, image.NewPaletted(image.Rect(0, 0, 5, 5), palette)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		imgData []byte
+		want    *Info
+		wantErr bool
+	}{
+		{
+			name:    "JPEG with EXIF",
+			imgData: jpegWithExif,
+			want: &Info{Width: 40, Height: 30, Format: "jpeg", Orientation: 6, FileSize: len(jpegWithExif),
+				Exif: &Exif{Make: "Canon", DateTime: "2024:05:01 10:20:30", Orientation: 6}},
+		},
+		{
+			name:    "JPEG without EXIF",
+			imgData: plainJPEG,
+			want:    &Info{Width: 40, Height: 30, Format: "jpeg", Orientation: 1, FileSize: len(plainJPEG)},
+		},
+		{
+			name:    "PNG with alpha",
+			imgData: transparentPNG.Bytes(),
+			want:    &Info{Width: 8, Height: 4, Format: "png", Orientation: 1, HasAlpha: true, FileSize: transparentPNG.Len()},
+		},
+		{
+			name:    "Opaque paletted PNG",
+			imgData: opaquePaletted.Bytes(),
+			want:    &Info{Width: 5, Height: 5, Format: "png", Orientation: 1, FileSize: opaquePaletted.Len()},
+		},
+		{
+			name:    "Not an image",
+			imgData: []byte("hello"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Describe(tt.imgData)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Describe() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Describe() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
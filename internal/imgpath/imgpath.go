@@ -14,10 +14,25 @@ func MakeFilePath(imgPath string, options *transformations.Options) string {
 	transformedFileName := fmt.Sprintf("%s.%s", strings.TrimSuffix(filepath.Base(imgPath), filepath.Ext(imgPath)), options.Format)
 	return filepath.Join(sanitizePath(url.PathEscape(imgPath)), options.Mode,
 		strconv.Itoa(options.Width), strconv.Itoa(options.Height),
-		strconv.FormatFloat(float64(options.AspectRatio), 'f', -1, 32), strconv.Itoa(options.Quality), transformedFileName)
+		strconv.FormatFloat(float64(options.AspectRatio), 'f', -1, 32), strconv.Itoa(options.Quality),
+		options.Variant(), transformedFileName)
+}
+
+// path of a file describing the original imgPath, e.g. its metadata, in the
+// directory holding its derivatives
+func MakeMetadataPath(imgPath string, name string) string {
+	return filepath.Join(sanitizePath(url.PathEscape(imgPath)), name)
 }
 
 func sanitizePath(path string) string {
+	// Underscores of the path are escaped first so that distinct sources, such
+	// as a:b.jpg and a_b.jpg, never share a directory
+	return legacySanitizePath(strings.ReplaceAll(path, "_", "%5F"))
+}
+
+// legacySanitizePath names directories as before underscores were escaped.
+// Purges also remove the directories named this way.
+func legacySanitizePath(path string) string {
 	// Replace potentially problematic characters with underscores
 	replacer := strings.NewReplacer(
 		"/", "_",
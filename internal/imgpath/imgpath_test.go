@@ -0,0 +1,135 @@
+package imgpath
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/transformations"
+)
+
+func TestPurge(t *testing.T) {
+	sources := []string{
+		"https://example.com/legal/a.jpg",
+		"https://example.com/legal/b.jpg",
+		"https://example.com/other/a.jpg",
+		"https://example.com/legal/a.jpg2",
+		"https://example.com/legal_a.jpg",
+		"https://example.com/legal:a.jpg",
+	}
+	// Cached before underscores were escaped
+	const legacySource = "https://example.com/old_b.jpg"
+
+	tests := []struct {
+		name      string
+		imgPath   string
+		prefix    bool
+		wantCount int
+		wantKept  []string
+	}{
+		{
+			name:      "Single source",
+			imgPath:   "https://example.com/legal/a.jpg",
+			wantCount: 2,
+			wantKept:  sources[1:],
+		},
+		{
+			name:      "Prefix",
+			imgPath:   "https://example.com/legal/",
+			prefix:    true,
+			wantCount: 6,
+			wantKept:  append(sources[2:3:3], sources[4:]...),
+		},
+		{
+			name:      "Prefix does not match sources sharing a legacy name",
+			imgPath:   "https://example.com/legal:",
+			prefix:    true,
+			wantCount: 2,
+			wantKept:  sources[:5],
+		},
+		{
+			name:      "Source with an underscore and its legacy name",
+			imgPath:   "https://example.com/legal_a.jpg",
+			wantCount: 4,
+			wantKept:  sources[:4],
+		},
+		{
+			name:      "Legacy directory",
+			imgPath:   legacySource,
+			wantCount: 2,
+			wantKept:  sources,
+		},
+		{
+			name:      "Legacy directory by prefix",
+			imgPath:   "https://example.com/old_",
+			prefix:    true,
+			wantCount: 2,
+			wantKept:  sources,
+		},
+		{
+			name:     "Unknown source",
+			imgPath:  "https://example.com/missing.jpg",
+			wantKept: sources,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			basePath := t.TempDir()
+			options := &transformations.Options{Mode: "fit", Width: 100, Quality: 100, Format: "webp"}
+			for _, source := range sources {
+				for _, filePath := range []string{MakeFilePath(source, options), MakeMetadataPath(source, "info.json")} {
+					fullPath := filepath.Join(basePath, filePath)
+					if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(fullPath, []byte("data"), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			legacyPath := filepath.Join(basePath, legacySanitizePath(url.PathEscape(legacySource)), "fit", "b.webp")
+			for _, filePath := range []string{legacyPath, filepath.Join(filepath.Dir(filepath.Dir(legacyPath)), "info.json")} {
+				if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filePath, []byte("data"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := Purge(basePath, tt.imgPath, tt.prefix)
+			if err != nil {
+				t.Fatalf("Purge() error = %v", err)
+			}
+			if got != tt.wantCount {
+				t.Errorf("Purge() = %d, want %d", got, tt.wantCount)
+			}
+			for _, source := range tt.wantKept {
+				if _, err := os.Stat(filepath.Join(basePath, MakeMetadataPath(source, "info.json"))); err != nil {
+					t.Errorf("%s was purged", source)
+				}
+			}
+		})
+	}
+}
+
+func TestSanitizePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		imgPath string
+		want    string
+	}{
+		{name: "Without underscores", imgPath: "https://example.com/a.jpg", want: "https_%2F%2Fexample.com%2Fa.jpg"},
+		{name: "Colon", imgPath: "https://example.com/a:b.jpg", want: "https_%2F%2Fexample.com%2Fa_b.jpg"},
+		{name: "Underscore", imgPath: "https://example.com/a_b.jpg", want: "https_%2F%2Fexample.com%2Fa%5Fb.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizePath(url.PathEscape(tt.imgPath)); got != tt.want {
+				t.Errorf("sanitizePath() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
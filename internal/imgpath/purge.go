@@ -0,0 +1,59 @@
+package imgpath
+
+import (
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// deletes the files of imgPath under basePath, as laid out by MakeFilePath and
+// MakeMetadataPath, or of every source starting with imgPath when prefix is
+// set. Returns the number of deleted files.
+func Purge(basePath string, imgPath string, prefix bool) (int, error) {
+	entries, err := os.ReadDir(basePath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Escaping and sanitizing work per character and map distinct sources to
+	// distinct names, so a directory name starts with this name only when its
+	// source starts with imgPath. Directories cached before underscores were
+	// escaped are removed too, their names are ambiguous so sources sharing them
+	// are purged as well and only need to be regenerated.
+	escaped := url.PathEscape(imgPath)
+	names := []string{sanitizePath(escaped), legacySanitizePath(escaped)}
+	deleted := 0
+	for _, entry := range entries {
+		if !matchesName(entry.Name(), names, prefix) {
+			continue
+		}
+		sourcePath := filepath.Join(basePath, entry.Name())
+		err := filepath.WalkDir(sourcePath, func(_ string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				deleted++
+			}
+			return err
+		})
+		if err != nil {
+			return deleted, err
+		}
+		if err := os.RemoveAll(sourcePath); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+func matchesName(entryName string, names []string, prefix bool) bool {
+	for _, name := range names {
+		if entryName == name || (prefix && strings.HasPrefix(entryName, name)) {
+			return true
+		}
+	}
+	return false
+}
@@ -5,12 +5,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/StrongerSoftworks/image-proxy/internal/imghttp"
+	"github.com/StrongerSoftworks/image-proxy/internal/originals"
 	"github.com/StrongerSoftworks/image-proxy/internal/transformations"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -28,9 +31,27 @@ func GetBucketName() string {
 	return bucket
 }
 
+// retrieves the key prefix of cached originals, ORIGINALS_PREFIX or originals/
+func GetOriginalsPrefix() string {
+	if prefix := os.Getenv("ORIGINALS_PREFIX"); prefix != "" {
+		return strings.TrimSuffix(prefix, "/") + "/"
+	}
+	return "originals/"
+}
+
 func MakeBucketFileKey(imgPath string, options *transformations.Options) string {
 	transformedFileName := fmt.Sprintf("%s.%s", strings.TrimSuffix(filepath.Base(imgPath), filepath.Ext(imgPath)), options.Format)
-	return fmt.Sprintf("%s/%s/%d/%d/%f/%d/%s", trimProtocol(imgPath), options.Mode, options.Width, options.Height, options.AspectRatio, options.Quality, transformedFileName)
+	key := fmt.Sprintf("%s/%s/%d/%d/%f/%d", trimProtocol(imgPath), options.Mode, options.Width, options.Height, options.AspectRatio, options.Quality)
+	if variant := options.Variant(); variant != "" {
+		key += "/" + variant
+	}
+	return key + "/" + transformedFileName
+}
+
+// key of a file describing the original imgPath, e.g. its metadata, under
+// the prefix holding its derivatives
+func MakeBucketMetadataKey(imgPath string, name string) string {
+	return trimProtocol(imgPath) + "/" + name
 }
 
 // checks if a file exists in the S3 bucket
@@ -82,6 +103,120 @@ func UploadImage(ctx context.Context, uploader *manager.Uploader, bucket, key st
 	return err
 }
 
+// OriginalStore keeps downloaded originals in the bucket under Prefix, with
+// their validators as object metadata
+type OriginalStore struct {
+	Client   *s3.Client
+	Uploader *manager.Uploader
+	Bucket   string
+	Prefix   string
+}
+
+func (store *OriginalStore) Read(imgPath string) (*originals.Original, error) {
+	output, err := store.Client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(store.key(imgPath)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &originals.Original{Data: data, Validators: originals.Validators{
+		ETag:         output.Metadata["etag"],
+		LastModified: output.Metadata["last-modified"],
+	}}, nil
+}
+
+func (store *OriginalStore) Write(imgPath string, original *originals.Original) error {
+	_, err := store.Uploader.Upload(context.TODO(), &s3.PutObjectInput{
+		Bucket:   aws.String(store.Bucket),
+		Key:      aws.String(store.key(imgPath)),
+		Body:     bytes.NewReader(original.Data),
+		Metadata: map[string]string{"etag": original.ETag, "last-modified": original.LastModified},
+	})
+	return err
+}
+
+func (store *OriginalStore) key(imgPath string) string {
+	return store.Prefix + trimProtocol(imgPath)
+}
+
+// deletes every derivative and cached original of imgPath, as laid out by
+// MakeBucketFileKey, MakeBucketMetadataKey and OriginalStore, or of every source
+// starting with imgPath when prefix is set. Returns the number of deleted objects.
+func Purge(ctx context.Context, client *s3.Client, bucket string, imgPath string, prefix bool) (int, error) {
+	source := trimProtocol(imgPath)
+	originalKey := GetOriginalsPrefix() + source
+
+	// Without prefix only the source's own keys match, the listings also
+	// return the keys of longer sources
+	derivativesDeleted, err := deleteObjects(ctx, client, bucket, source, func(key string) bool {
+		return prefix || isSourceKey(source, key)
+	})
+	if err != nil {
+		return derivativesDeleted, err
+	}
+	originalsDeleted, err := deleteObjects(ctx, client, bucket, originalKey, func(key string) bool {
+		return prefix || key == originalKey
+	})
+	return derivativesDeleted + originalsDeleted, err
+}
+
+// Keys below a source's prefix are either metadata files directly under it or
+// derivatives, and their source records, laid out by MakeBucketFileKey
+var sourceKeyPattern = regexp.MustCompile(`^(?:[^/]+|(?:fit|crop|fill|pad)/\d+/\d+/[0-9.]+/\d+/(?:[^/]+/)?[^/]+)$`)
+
+// reports whether key belongs to source rather than to a longer source under
+// it, e.g. https://example.com/a.jpg/b.png
+func isSourceKey(source string, key string) bool {
+	rest, found := strings.CutPrefix(key, source+"/")
+	return found && sourceKeyPattern.MatchString(rest)
+}
+
+// deletes the objects under keyPrefix that match
+func deleteObjects(ctx context.Context, client *s3.Client, bucket string, keyPrefix string, match func(key string) bool) (int, error) {
+	deleted := 0
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(keyPrefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return deleted, err
+		}
+
+		// A page holds at most 1000 keys, the limit of a DeleteObjects request
+		var objects []types.ObjectIdentifier
+		for _, object := range page.Contents {
+			if match(aws.ToString(object.Key)) {
+				objects = append(objects, types.ObjectIdentifier{Key: object.Key})
+			}
+		}
+		if len(objects) == 0 {
+			continue
+		}
+		output, err := client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return deleted, err
+		}
+		if len(output.Errors) > 0 {
+			return deleted + len(objects) - len(output.Errors), fmt.Errorf("failed to delete %s: %s",
+				aws.ToString(output.Errors[0].Key), aws.ToString(output.Errors[0].Message))
+		}
+		deleted += len(objects)
+	}
+	return deleted, nil
+}
+
 func InitAWS(ctx context.Context) *s3.Client {
 	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(os.Getenv("AWS_REGION")))
 	if err != nil {
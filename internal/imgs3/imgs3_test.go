@@ -0,0 +1,35 @@
+package imgs3
+
+import (
+	"testing"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/transformations"
+)
+
+func TestIsSourceKey(t *testing.T) {
+	source := trimProtocol("https://example.com/a.jpg")
+	options := &transformations.Options{Mode: "fit", Width: 100, Quality: 80, Format: "webp"}
+	derivativeKey := MakeBucketFileKey("https://example.com/a.jpg", options)
+	longerKey := MakeBucketFileKey("https://example.com/a.jpg/b.png", options)
+
+	tests := []struct {
+		name string
+		key  string
+		want bool
+	}{
+		{name: "Derivative", key: derivativeKey, want: true},
+		{name: "Source record", key: derivativeKey + ".source.json", want: true},
+		{name: "Derivative with variant", key: source + "/crop/10/10/1.500000/80/blur-2/a.webp", want: true},
+		{name: "Metadata", key: MakeBucketMetadataKey("https://example.com/a.jpg", "info.json"), want: true},
+		{name: "Derivative of a longer source", key: longerKey, want: false},
+		{name: "Metadata of a longer source", key: MakeBucketMetadataKey("https://example.com/a.jpg/b.png", "info.json"), want: false},
+		{name: "Sibling with the same prefix", key: MakeBucketMetadataKey("https://example.com/a.jpg2", "info.json"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSourceKey(source, tt.key); got != tt.want {
+				t.Errorf("isSourceKey(%s) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
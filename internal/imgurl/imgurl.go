@@ -0,0 +1,102 @@
+package imgurl
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Short option names accepted in the path scheme
+var shortNames = map[string]string{
+	"w":   "width",
+	"h":   "height",
+	"f":   "format",
+	"q":   "quality",
+	"m":   "mode",
+	"r":   "ratio",
+	"p":   "preset",
+	"rot": "rotate",
+}
+
+// parses a path-encoded request into the query parameters used by /proxy.
+// The path is either /<options>/plain/<url>[@<format>] or
+// /<options>/<base64url source>[.<format>], where options is a comma separated
+// list of name:value pairs (e.g. w:300,h:200,f:webp) or "-" for none. Commas
+// and slashes inside values must be percent-encoded. escapedPath is the path
+// as sent by the client, before percent-decoding, and rawQuery its query
+// string. A query string would be cut off a plain source url, so it must be
+// percent-encoded into the path and plain urls with a query are rejected.
+func ParsePath(escapedPath string, rawQuery string) (url.Values, error) {
+	optionsSegment, source, found := strings.Cut(strings.TrimPrefix(escapedPath, "/"), "/")
+	if !found || source == "" {
+		return nil, fmt.Errorf("expected /<options>/<source>")
+	}
+
+	values, err := parseOptions(optionsSegment)
+	if err != nil {
+		return nil, err
+	}
+
+	var imgPath, format string
+	if plain, isPlain := strings.CutPrefix(source, "plain/"); isPlain {
+		if rawQuery != "" {
+			return nil, fmt.Errorf("the query string of a plain source url must be percent-encoded")
+		}
+		plain, format, _ = strings.Cut(plain, "@")
+		imgPath, err = url.PathUnescape(plain)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source url: %v", err)
+		}
+		imgPath = restoreSchemeSlashes(imgPath)
+	} else {
+		encoded, extension, _ := strings.Cut(source, ".")
+		format = extension
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 source url: %v", err)
+		}
+		imgPath = string(decoded)
+	}
+
+	if imgPath == "" {
+		return nil, fmt.Errorf("missing source url")
+	}
+	values.Set("img", imgPath)
+	if format != "" {
+		values.Set("format", format)
+	}
+	return values, nil
+}
+
+func parseOptions(segment string) (url.Values, error) {
+	values := url.Values{}
+	if segment == "-" {
+		return values, nil
+	}
+	for _, option := range strings.Split(segment, ",") {
+		name, value, found := strings.Cut(option, ":")
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid option: %s. expected name:value", option)
+		}
+		unescaped, err := url.PathUnescape(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid option: %s. %v", option, err)
+		}
+		if longName, exists := shortNames[name]; exists {
+			name = longName
+		}
+		values.Set(name, unescaped)
+	}
+	return values, nil
+}
+
+// Path cleaning collapses the "//" after the scheme of a plain source url
+func restoreSchemeSlashes(imgPath string) string {
+	for _, scheme := range []string{"http:", "https:"} {
+		if rest, found := strings.CutPrefix(imgPath, scheme+"/"); found && !strings.HasPrefix(rest, "/") {
+			return scheme + "//" + rest
+		}
+	}
+	return imgPath
+}
@@ -0,0 +1,82 @@
+package imgurl
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		name        string
+		escapedPath string
+		rawQuery    string
+		want        url.Values
+		wantErr     bool
+	}{
+		{
+			name:        "Plain source",
+			escapedPath: "/w:300,h:200,f:webp/plain/https://example.com/a.jpg",
+			want:        url.Values{"width": {"300"}, "height": {"200"}, "format": {"webp"}, "img": {"https://example.com/a.jpg"}},
+		},
+		{
+			name:        "Plain source after path cleaning",
+			escapedPath: "/w:300/plain/https:/example.com/a.jpg",
+			want:        url.Values{"width": {"300"}, "img": {"https://example.com/a.jpg"}},
+		},
+		{
+			name:        "Plain source with format suffix",
+			escapedPath: "/q:80/plain/https%3A%2F%2Fexample.com%2Fa.jpg%3Fv%3D2@avif",
+			want:        url.Values{"quality": {"80"}, "format": {"avif"}, "img": {"https://example.com/a.jpg?v=2"}},
+		},
+		{
+			name:        "Base64 source with extension",
+			escapedPath: "/w:300,rect:pct:10%2C10%2C50%2C50/aHR0cHM6Ly9leGFtcGxlLmNvbS9hLmpwZw.png",
+			want:        url.Values{"width": {"300"}, "rect": {"pct:10,10,50,50"}, "format": {"png"}, "img": {"https://example.com/a.jpg"}},
+		},
+		{
+			name:        "No options",
+			escapedPath: "/-/aHR0cHM6Ly9leGFtcGxlLmNvbS9hLmpwZw",
+			want:        url.Values{"img": {"https://example.com/a.jpg"}},
+		},
+		{
+			name:        "Missing source",
+			escapedPath: "/w:300",
+			wantErr:     true,
+		},
+		{
+			name:        "Malformed option",
+			escapedPath: "/w300/plain/https://example.com/a.jpg",
+			wantErr:     true,
+		},
+		{
+			name:        "Plain source with an unencoded query string",
+			escapedPath: "/w:300/plain/https://example.com/a.jpg",
+			rawQuery:    "v=2",
+			wantErr:     true,
+		},
+		{
+			name:        "Base64 source ignores the query string",
+			escapedPath: "/-/aHR0cHM6Ly9leGFtcGxlLmNvbS9hLmpwZz92PTI",
+			rawQuery:    "cb=1",
+			want:        url.Values{"img": {"https://example.com/a.jpg?v=2"}},
+		},
+		{
+			name:        "Invalid base64",
+			escapedPath: "/w:300/not*base64",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePath(tt.escapedPath, tt.rawQuery)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParsePath() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package originals
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/diskcache"
+	"github.com/StrongerSoftworks/image-proxy/internal/imgpath"
+)
+
+// DirStore keeps originals on the local disk under a directory, each as a data
+// file and a JSON file of its validators
+type DirStore string
+
+func (dir DirStore) Read(imgPath string) (*Original, error) {
+	dataPath := dir.path(imgPath)
+	data, err := os.ReadFile(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	validatorsData, err := os.ReadFile(dataPath + ".json")
+	if err != nil {
+		return nil, err
+	}
+	original := &Original{Data: data}
+	if err := json.Unmarshal(validatorsData, &original.Validators); err != nil {
+		return nil, fmt.Errorf("invalid validators of %s: %w", imgPath, err)
+	}
+	return original, nil
+}
+
+func (dir DirStore) Write(imgPath string, original *Original) error {
+	dataPath := dir.path(imgPath)
+	validatorsData, err := json.Marshal(original.Validators)
+	if err != nil {
+		return err
+	}
+	// The validators are removed first and written last so a partially written
+	// original is never revalidated and served
+	if err := os.Remove(dataPath + ".json"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove validators: %w", err)
+	}
+	if err := diskcache.WriteFile(dataPath, original.Data, 0644); err != nil {
+		return err
+	}
+	return diskcache.WriteFile(dataPath+".json", validatorsData, 0644)
+}
+
+// returns the paths of the files keeping the original of imgPath
+func (dir DirStore) Files(imgPath string) []string {
+	dataPath := dir.path(imgPath)
+	return []string{dataPath, dataPath + ".json"}
+}
+
+func (dir DirStore) path(imgPath string) string {
+	return filepath.Join(string(dir), imgpath.MakeMetadataPath(imgPath, "original"))
+}
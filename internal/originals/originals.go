@@ -0,0 +1,126 @@
+package originals
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/imghttp"
+)
+
+// Validators identify a version of an original, from its upstream ETag and Last-Modified headers
+type Validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// Original is a downloaded source image and the validators used to revalidate it
+type Original struct {
+	Data []byte
+	Validators
+}
+
+// Store keeps downloaded originals, separate from the derivatives
+type Store interface {
+	Read(imgPath string) (*Original, error)
+	Write(imgPath string, original *Original) error
+}
+
+// Cache downloads originals, keeping them in a store and revalidating them
+// with conditional requests instead of downloading them again
+type Cache struct {
+	store Store
+}
+
+// creates a cache of originals in store, nil to always download them
+func New(store Store) *Cache {
+	return &Cache{store: store}
+}
+
+// returns the current original at imgPath, from the store when upstream
+// confirms it has not changed. A stored original is also used when upstream
+// can not be reached.
+func (cache *Cache) Get(imgPath string) (*Original, error) {
+	var cached *Original
+	if cache.store != nil {
+		cached, _ = cache.store.Read(imgPath)
+	}
+
+	var validators Validators
+	if cached != nil {
+		validators = cached.Validators
+	}
+	resp, err := conditionalGet(imgPath, validators)
+	if err != nil {
+		if cached != nil {
+			log.Printf("Using cached original of %s: %v", imgPath, err)
+			return cached, nil
+		}
+		return nil, fmt.Errorf("failed to fetch image: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		return cached, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching image: HTTP %d", resp.StatusCode)
+	}
+
+	data, err := imghttp.ReadSource(resp)
+	if err != nil {
+		return nil, err
+	}
+	original := &Original{Data: data, Validators: responseValidators(resp)}
+
+	// Without validators the original could never be revalidated
+	if cache.store != nil && (original.ETag != "" || original.LastModified != "") {
+		if err := cache.store.Write(imgPath, original); err != nil {
+			log.Printf("Error caching original of %s: %v", imgPath, err)
+		}
+	}
+	return original, nil
+}
+
+// reports whether the original at imgPath is no longer the version identified
+// by validators. Without validators the version is unknown and counts as changed.
+func Changed(imgPath string, validators Validators) (bool, error) {
+	if validators == (Validators{}) {
+		return true, nil
+	}
+
+	resp, err := conditionalGet(imgPath, validators)
+	if err != nil {
+		return false, fmt.Errorf("failed to revalidate image: %v", err)
+	}
+	// Only the status is needed, the body of a changed original is not read
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+	case http.StatusOK:
+		return responseValidators(resp) != validators, nil
+	default:
+		return false, fmt.Errorf("error revalidating image: HTTP %d", resp.StatusCode)
+	}
+}
+
+// requests imgPath unless it still matches validators
+func conditionalGet(imgPath string, validators Validators) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, imgPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	if validators.ETag != "" {
+		request.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		request.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+	return http.DefaultClient.Do(request)
+}
+
+func responseValidators(resp *http.Response) Validators {
+	return Validators{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+}
@@ -0,0 +1,145 @@
+package originals
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/imghttp"
+)
+
+func TestCacheGet(t *testing.T) {
+	etag := `"v1"`
+	body := "first"
+	status := http.StatusOK
+	downloads := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+
+	cache := New(DirStore(t.TempDir()))
+	imgPath := upstream.URL + "/a.jpg"
+	get := func(wantBody string, wantDownloads int) {
+		t.Helper()
+		original, err := cache.Get(imgPath)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if string(original.Data) != wantBody || downloads != wantDownloads {
+			t.Errorf("Get() = %s after %d downloads, want %s after %d", original.Data, downloads, wantBody, wantDownloads)
+		}
+	}
+
+	get("first", 1)
+	// Unchanged originals are revalidated instead of downloaded
+	get("first", 1)
+
+	etag, body = `"v2"`, "second"
+	get("second", 2)
+	get("second", 2)
+
+	// Removed originals are not served from the cache
+	status = http.StatusNotFound
+	if _, err := cache.Get(imgPath); err == nil {
+		t.Errorf("Get() of a removed original succeeded")
+	}
+}
+
+func TestCacheGetUpstreamDown(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Write([]byte("original"))
+	}))
+	imgPath := upstream.URL + "/a.jpg"
+
+	cache := New(DirStore(t.TempDir()))
+	if _, err := cache.Get(imgPath); err != nil {
+		t.Fatal(err)
+	}
+	upstream.Close()
+
+	original, err := cache.Get(imgPath)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(original.Data) != "original" || original.LastModified != "Mon, 02 Jan 2006 15:04:05 GMT" {
+		t.Errorf("Get() = %+v, want the cached original", original)
+	}
+}
+
+func TestChanged(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.jpg" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"v2"`)
+		if r.Header.Get("If-None-Match") == `"v2"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("original"))
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name       string
+		path       string
+		validators Validators
+		want       bool
+		wantErr    bool
+	}{
+		{name: "Unchanged", path: "/a.jpg", validators: Validators{ETag: `"v2"`}, want: false},
+		{name: "Changed", path: "/a.jpg", validators: Validators{ETag: `"v1"`}, want: true},
+		{name: "Unknown version", path: "/a.jpg", want: true},
+		{name: "Upstream error", path: "/missing.jpg", validators: Validators{ETag: `"v1"`}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Changed(upstream.URL+tt.path, tt.validators)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Changed() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Changed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheGetTooLarge(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(make([]byte, 2048))
+	}))
+	defer upstream.Close()
+
+	t.Setenv("MAX_SOURCE_BYTES", "1024")
+	if err := imghttp.LoadSettings(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.Unsetenv("MAX_SOURCE_BYTES")
+		imghttp.LoadSettings()
+	}()
+
+	dir := t.TempDir()
+	if _, err := New(DirStore(dir)).Get(upstream.URL + "/a.jpg"); err == nil {
+		t.Errorf("Get() of an original over MAX_SOURCE_BYTES succeeded")
+	}
+	if _, err := DirStore(dir).Read(upstream.URL + "/a.jpg"); err == nil {
+		t.Errorf("original over MAX_SOURCE_BYTES was cached")
+	}
+}
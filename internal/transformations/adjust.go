@@ -0,0 +1,97 @@
+package transformations
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// parses the brightness, contrast, saturation, gamma and grayscale query parameters
+func parseAdjustments(query func(string) string, options *Options) error {
+	var err error
+	if options.Brightness, err = parseFloatRange(query, "brightness", -100, 100); err != nil {
+		return err
+	}
+	if options.Contrast, err = parseFloatRange(query, "contrast", -100, 100); err != nil {
+		return err
+	}
+	if options.Saturation, err = parseFloatRange(query, "saturation", -100, 500); err != nil {
+		return err
+	}
+	if options.Gamma, err = parseFloatRange(query, "gamma", 0.1, 10); err != nil {
+		return err
+	}
+	// A gamma of 1 leaves the image unchanged
+	if options.Gamma == 1 {
+		options.Gamma = 0
+	}
+
+	if grayscaleQuery := query("grayscale"); grayscaleQuery != "" {
+		options.Grayscale, err = strconv.ParseBool(grayscaleQuery)
+		if err != nil {
+			return fmt.Errorf("invalid grayscale: %s", grayscaleQuery)
+		}
+	}
+
+	return nil
+}
+
+// parses a float query parameter that must lie within [min, max], zero when it is not set
+func parseFloatRange(query func(string) string, name string, minValue float64, maxValue float64) (float64, error) {
+	value := query(name)
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(parsed) || parsed < minValue || parsed > maxValue {
+		return 0, fmt.Errorf("invalid %s: %s. must be between %v and %v", name, value, minValue, maxValue)
+	}
+	return parsed, nil
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func adjustmentsVariant(options *Options) []string {
+	var parts []string
+	if options.Brightness != 0 {
+		parts = append(parts, "brightness-"+formatFloat(options.Brightness))
+	}
+	if options.Contrast != 0 {
+		parts = append(parts, "contrast-"+formatFloat(options.Contrast))
+	}
+	if options.Saturation != 0 {
+		parts = append(parts, "saturation-"+formatFloat(options.Saturation))
+	}
+	if options.Gamma != 0 {
+		parts = append(parts, "gamma-"+formatFloat(options.Gamma))
+	}
+	if options.Grayscale {
+		parts = append(parts, "grayscale")
+	}
+	return parts
+}
+
+// applies the color adjustments, run after resizing so fewer pixels are processed
+func adjust(img image.Image, options *Options) image.Image {
+	if options.Brightness != 0 {
+		img = imaging.AdjustBrightness(img, options.Brightness)
+	}
+	if options.Contrast != 0 {
+		img = imaging.AdjustContrast(img, options.Contrast)
+	}
+	if options.Saturation != 0 {
+		img = imaging.AdjustSaturation(img, options.Saturation)
+	}
+	if options.Gamma != 0 {
+		img = imaging.AdjustGamma(img, options.Gamma)
+	}
+	if options.Grayscale {
+		img = imaging.Grayscale(img)
+	}
+	return img
+}
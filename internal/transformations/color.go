@@ -0,0 +1,61 @@
+package transformations
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// parses a hex color (RGB, RRGGBB or RRGGBBAA, with or without a leading #)
+func ParseColor(hexColor string) (color.NRGBA, error) {
+	value := strings.TrimPrefix(strings.ToLower(hexColor), "#")
+	if value == "transparent" {
+		return color.NRGBA{}, nil
+	}
+	if len(value) == 3 {
+		value = string([]byte{value[0], value[0], value[1], value[1], value[2], value[2]})
+	}
+	if len(value) == 6 {
+		value += "ff"
+	}
+
+	channels, err := hex.DecodeString(value)
+	if err != nil || len(channels) != 4 {
+		return color.NRGBA{}, fmt.Errorf("invalid color: %s", hexColor)
+	}
+	return color.NRGBA{R: channels[0], G: channels[1], B: channels[2], A: channels[3]}, nil
+}
+
+// formats a color the way it is stored in the options and cache keys
+func FormatColor(c color.NRGBA) string {
+	return hex.EncodeToString([]byte{c.R, c.G, c.B, c.A})
+}
+
+// the background used to fill areas not covered by the image, transparent when unset
+func (options *Options) backgroundColor() color.NRGBA {
+	if options.Background == DominantBackground {
+		return options.dominant
+	}
+	background, err := ParseColor(options.Background)
+	if err != nil {
+		return color.NRGBA{}
+	}
+	return background
+}
+
+// the color of areas added around the image, the background or white when
+// there is none and the output has no alpha
+func (options *Options) fillColor() color.NRGBA {
+	if options.Background == "" && isJPEG(options.Format) {
+		return color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	}
+	return options.backgroundColor()
+}
+
+func backgroundVariant(options *Options) []string {
+	if options.Background == "" {
+		return nil
+	}
+	return []string{"bg-" + options.Background}
+}
@@ -0,0 +1,110 @@
+package transformations
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// Caps on the effect parameters, the cost of a blur grows with its sigma
+const (
+	maxBlurSigma    = 50
+	maxSharpenSigma = 10
+	maxSharpenGain  = 5
+	maxPixelateSize = 200
+)
+
+// parses the blur, sharpen, sharpen_amount and pixelate query parameters
+func parseEffects(query func(string) string, options *Options) error {
+	var err error
+	if options.Blur, err = parseFloatRange(query, "blur", 0, maxBlurSigma); err != nil {
+		return err
+	}
+	if options.Sharpen, err = parseFloatRange(query, "sharpen", 0, maxSharpenSigma); err != nil {
+		return err
+	}
+	if options.SharpenAmount, err = parseFloatRange(query, "sharpen_amount", 0, maxSharpenGain); err != nil {
+		return err
+	}
+	// An amount of 1 is what imaging.Sharpen applies
+	if options.SharpenAmount == 1 || options.Sharpen == 0 {
+		options.SharpenAmount = 0
+	}
+
+	if pixelateQuery := query("pixelate"); pixelateQuery != "" {
+		options.Pixelate, err = strconv.Atoi(pixelateQuery)
+		if err != nil || options.Pixelate < 0 || options.Pixelate > maxPixelateSize {
+			return fmt.Errorf("invalid pixelate: %s. must be between 0 and %d", pixelateQuery, maxPixelateSize)
+		}
+	}
+
+	return nil
+}
+
+func effectsVariant(options *Options) []string {
+	var parts []string
+	if options.Blur != 0 {
+		parts = append(parts, "blur-"+formatFloat(options.Blur))
+	}
+	if options.Sharpen != 0 {
+		parts = append(parts, "sharpen-"+formatFloat(options.Sharpen))
+	}
+	if options.SharpenAmount != 0 {
+		parts = append(parts, "amount-"+formatFloat(options.SharpenAmount))
+	}
+	if options.Pixelate > 1 {
+		parts = append(parts, "pixelate-"+strconv.Itoa(options.Pixelate))
+	}
+	return parts
+}
+
+// applies blur, sharpen and pixelate. downscale is the ratio between the
+// source and resized width, used to decide whether to sharpen automatically.
+func applyEffects(img image.Image, options *Options, downscale float64) image.Image {
+	if options.Blur != 0 {
+		img = imaging.Blur(img, options.Blur)
+	}
+
+	if options.Sharpen != 0 {
+		img = sharpen(img, options.Sharpen, options.SharpenAmount)
+	} else if settings.AutoSharpenRatio > 0 && downscale >= settings.AutoSharpenRatio && options.Blur == 0 {
+		img = imaging.Sharpen(img, settings.AutoSharpenSigma)
+	}
+
+	if options.Pixelate > 1 {
+		img = pixelate(img, options.Pixelate)
+	}
+	return img
+}
+
+// unsharp mask, amount scales the difference between the image and its blur
+func sharpen(img image.Image, sigma float64, amount float64) image.Image {
+	if amount == 0 {
+		return imaging.Sharpen(img, sigma)
+	}
+
+	src := imaging.Clone(img)
+	blurred := imaging.Blur(src, sigma)
+	for i := 0; i < len(src.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			value := float64(src.Pix[i+c]) + amount*(float64(src.Pix[i+c])-float64(blurred.Pix[i+c]))
+			src.Pix[i+c] = uint8(math.Max(0, math.Min(255, math.Round(value))))
+		}
+	}
+	return src
+}
+
+// averages blocks of size x size pixels and scales them back up without smoothing
+func pixelate(img image.Image, size int) image.Image {
+	width := img.Bounds().Dx()
+	height := img.Bounds().Dy()
+	blocksX := (width + size - 1) / size
+	blocksY := (height + size - 1) / size
+	small := imaging.Resize(img, blocksX, blocksY, imaging.Box)
+	// Scaling to whole blocks keeps them square, the overhang is cropped off
+	blocks := imaging.Resize(small, blocksX*size, blocksY*size, imaging.NearestNeighbor)
+	return imaging.Crop(blocks, image.Rect(0, 0, width, height))
+}
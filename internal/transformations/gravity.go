@@ -0,0 +1,53 @@
+package transformations
+
+import "image"
+
+// Gravity positions
+const (
+	Center    = "center"
+	North     = "north"
+	South     = "south"
+	East      = "east"
+	West      = "west"
+	NorthEast = "northeast"
+	NorthWest = "northwest"
+	SouthEast = "southeast"
+	SouthWest = "southwest"
+)
+
+func validateGravity(gravity string) bool {
+	validGravities := map[string]bool{
+		Center:    true,
+		North:     true,
+		South:     true,
+		East:      true,
+		West:      true,
+		NorthEast: true,
+		NorthWest: true,
+		SouthEast: true,
+		SouthWest: true,
+	}
+	return validGravities[gravity]
+}
+
+// returns the top-left point placing an item of the given size inside the
+// canvas at gravity, kept margin pixels away from the edges it is anchored to
+func gravityPosition(gravity string, canvas image.Rectangle, size image.Point, margin int) image.Point {
+	x := canvas.Min.X + (canvas.Dx()-size.X)/2
+	y := canvas.Min.Y + (canvas.Dy()-size.Y)/2
+
+	switch gravity {
+	case North, NorthEast, NorthWest:
+		y = canvas.Min.Y + margin
+	case South, SouthEast, SouthWest:
+		y = canvas.Max.Y - size.Y - margin
+	}
+	switch gravity {
+	case West, NorthWest, SouthWest:
+		x = canvas.Min.X + margin
+	case East, NorthEast, SouthEast:
+		x = canvas.Max.X - size.X - margin
+	}
+
+	return image.Pt(x, y)
+}
@@ -0,0 +1,129 @@
+package transformations
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"slices"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// Flip directions
+const (
+	FlipHorizontal = "h"
+	FlipVertical   = "v"
+	FlipBoth       = "hv"
+)
+
+func validateFlip(flip string) bool {
+	validFlips := map[string]bool{
+		FlipHorizontal: true,
+		FlipVertical:   true,
+		FlipBoth:       true,
+	}
+	return validFlips[flip]
+}
+
+// parses the rotate, flip and bg query parameters
+func parseOrientation(query func(string) string, options *Options) error {
+	if rotateQuery := query("rotate"); rotateQuery != "" {
+		angle, err := strconv.ParseFloat(rotateQuery, 64)
+		if err != nil || math.IsNaN(angle) || math.IsInf(angle, 0) {
+			return fmt.Errorf("invalid rotate: %s", rotateQuery)
+		}
+		// Normalise to [0, 360) so equivalent angles share a cache key
+		options.Rotate = math.Mod(math.Mod(angle, 360)+360, 360)
+	}
+
+	if flipQuery := query("flip"); flipQuery != "" {
+		if flipQuery == "vh" {
+			flipQuery = FlipBoth
+		}
+		if !validateFlip(flipQuery) {
+			return fmt.Errorf("invalid flip: %s", flipQuery)
+		}
+		options.Flip = flipQuery
+	}
+
+	if bgQuery := query("bg"); bgQuery == DominantBackground {
+		options.Background = DominantBackground
+	} else if bgQuery != "" {
+		background, err := ParseColor(bgQuery)
+		if err != nil {
+			return fmt.Errorf("invalid bg: %s", bgQuery)
+		}
+		options.Background = FormatColor(background)
+	}
+
+	return nil
+}
+
+func orientationVariant(options *Options) []string {
+	var parts []string
+	if options.Rotate != 0 {
+		parts = append(parts, "rotate-"+formatFloat(options.Rotate))
+	}
+	if options.Flip != "" {
+		parts = append(parts, "flip-"+options.Flip)
+	}
+	return parts
+}
+
+// rotates the image clockwise by options.Rotate degrees and then mirrors it.
+// Arbitrary angles grow the canvas and fill the corners like pad.
+func orient(img image.Image, options *Options) image.Image {
+	switch options.Rotate {
+	case 0:
+	case 90:
+		img = imaging.Rotate270(img)
+	case 180:
+		img = imaging.Rotate180(img)
+	case 270:
+		img = imaging.Rotate90(img)
+	default:
+		// imaging rotates counter-clockwise
+		img = imaging.Rotate(img, -options.Rotate, options.fillColor())
+	}
+
+	switch options.Flip {
+	case FlipHorizontal:
+		img = imaging.FlipH(img)
+	case FlipVertical:
+		img = imaging.FlipV(img)
+	case FlipBoth:
+		img = imaging.FlipV(imaging.FlipH(img))
+	}
+
+	return img
+}
+
+// the size of an image of the given size after orient, computed like
+// imaging.Rotate sizes its canvas
+func orientedSize(size image.Point, options *Options) image.Point {
+	switch options.Rotate {
+	case 0, 180:
+		return size
+	case 90, 270:
+		return image.Pt(size.Y, size.X)
+	}
+	if size.X <= 0 || size.Y <= 0 {
+		return image.Point{}
+	}
+
+	// imaging rotates counter-clockwise
+	sin, cos := math.Sincos(math.Pi * -options.Rotate / 180)
+	width, height := float64(size.X-1), float64(size.Y-1)
+	xs := []float64{0, width * cos, width*cos - height*sin, -height * sin}
+	ys := []float64{0, width * sin, width*sin + height*cos, height * cos}
+	rotatedWidth := slices.Max(xs) - slices.Min(xs) + 1
+	if rotatedWidth-math.Floor(rotatedWidth) > 0.1 {
+		rotatedWidth++
+	}
+	rotatedHeight := slices.Max(ys) - slices.Min(ys) + 1
+	if rotatedHeight-math.Floor(rotatedHeight) > 0.1 {
+		rotatedHeight++
+	}
+	return image.Pt(int(rotatedWidth), int(rotatedHeight))
+}
@@ -0,0 +1,190 @@
+package transformations
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+
+	"github.com/disintegration/imaging"
+)
+
+// DominantBackground as the bg parameter fills with the dominant color of the source
+const DominantBackground = "dominant"
+
+// MaxPaletteColors caps the number of colors Palette extracts
+const MaxPaletteColors = 16
+
+const (
+	paletteSampleSize = 64
+	paletteIterations = 10
+	// Pixels more transparent than this are left out of the palette
+	paletteMinAlpha = 128
+)
+
+// PaletteColor is a color of the image and the share of pixels closest to it
+type PaletteColor struct {
+	Color string  `json:"color"`
+	Share float64 `json:"share"`
+}
+
+type paletteCluster struct {
+	color  color.NRGBA
+	pixels int
+}
+
+// extracts up to count colors from a downscaled copy of the image, most common
+// first. The colors are refined with k-means from a median cut of the pixels.
+func Palette(img image.Image, count int) []PaletteColor {
+	clusters, total := palette(img, count)
+	colors := make([]PaletteColor, len(clusters))
+	for i, cluster := range clusters {
+		colors[i] = PaletteColor{
+			Color: fmt.Sprintf("#%02x%02x%02x", cluster.color.R, cluster.color.G, cluster.color.B),
+			Share: float64(cluster.pixels) / float64(total),
+		}
+	}
+	return colors
+}
+
+// the most common color of the image
+func dominantColor(img image.Image) color.NRGBA {
+	clusters, _ := palette(img, 5)
+	if len(clusters) == 0 {
+		return color.NRGBA{}
+	}
+	return clusters[0].color
+}
+
+func palette(img image.Image, count int) ([]paletteCluster, int) {
+	sample := imaging.Fit(img, paletteSampleSize, paletteSampleSize, imaging.Box)
+	var pixels, transparent [][3]int
+	for y := 0; y < sample.Bounds().Dy(); y++ {
+		for x := 0; x < sample.Bounds().Dx(); x++ {
+			pixel := sample.NRGBAAt(x, y)
+			rgb := [3]int{int(pixel.R), int(pixel.G), int(pixel.B)}
+			if pixel.A >= paletteMinAlpha {
+				pixels = append(pixels, rgb)
+			} else {
+				transparent = append(transparent, rgb)
+			}
+		}
+	}
+	// A mostly transparent image still has a palette
+	if len(pixels) == 0 {
+		pixels = transparent
+	}
+	if len(pixels) == 0 || count <= 0 {
+		return nil, 0
+	}
+
+	centers := medianCut(pixels, count)
+	assignments := make([]int, len(pixels))
+	for iteration := 0; iteration < paletteIterations; iteration++ {
+		changed := false
+		for i, pixel := range pixels {
+			nearest := nearestCenter(centers, pixel)
+			if nearest != assignments[i] || iteration == 0 {
+				assignments[i] = nearest
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		centers = clusterMeans(pixels, assignments, centers)
+	}
+
+	clusters := make([]paletteCluster, len(centers))
+	for i, center := range centers {
+		clusters[i].color = color.NRGBA{R: uint8(center[0]), G: uint8(center[1]), B: uint8(center[2]), A: 255}
+	}
+	for _, assignment := range assignments {
+		clusters[assignment].pixels++
+	}
+	clusters = slices.DeleteFunc(clusters, func(cluster paletteCluster) bool { return cluster.pixels == 0 })
+	slices.SortStableFunc(clusters, func(a, b paletteCluster) int { return b.pixels - a.pixels })
+	return clusters, len(pixels)
+}
+
+// splits the pixels into count boxes, each time halving the box with the widest
+// channel range at its median, and returns the mean of every box
+func medianCut(pixels [][3]int, count int) [][3]int {
+	boxes := [][][3]int{slices.Clone(pixels)}
+	for len(boxes) < count {
+		widest, channel, widestRange := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			for c := 0; c < 3; c++ {
+				low, high := 255, 0
+				for _, pixel := range box {
+					low, high = min(low, pixel[c]), max(high, pixel[c])
+				}
+				if high-low > widestRange {
+					widest, channel, widestRange = i, c, high-low
+				}
+			}
+		}
+		// Every box holds a single color
+		if widest < 0 {
+			break
+		}
+		box := boxes[widest]
+		slices.SortFunc(box, func(a, b [3]int) int { return a[channel] - b[channel] })
+		boxes[widest] = box[:len(box)/2]
+		boxes = append(boxes, box[len(box)/2:])
+	}
+
+	centers := make([][3]int, len(boxes))
+	for i, box := range boxes {
+		var sum [3]int
+		for _, pixel := range box {
+			for c := 0; c < 3; c++ {
+				sum[c] += pixel[c]
+			}
+		}
+		for c := 0; c < 3; c++ {
+			centers[i][c] = (sum[c] + len(box)/2) / len(box)
+		}
+	}
+	return centers
+}
+
+func nearestCenter(centers [][3]int, pixel [3]int) int {
+	nearest, nearestDistance := 0, -1
+	for i, center := range centers {
+		distance := 0
+		for c := 0; c < 3; c++ {
+			distance += (center[c] - pixel[c]) * (center[c] - pixel[c])
+		}
+		if nearestDistance < 0 || distance < nearestDistance {
+			nearest, nearestDistance = i, distance
+		}
+	}
+	return nearest
+}
+
+// moves every center to the mean of its pixels, centers without pixels stay put
+func clusterMeans(pixels [][3]int, assignments []int, centers [][3]int) [][3]int {
+	sums := make([][3]int, len(centers))
+	counts := make([]int, len(centers))
+	for i, pixel := range pixels {
+		for c := 0; c < 3; c++ {
+			sums[assignments[i]][c] += pixel[c]
+		}
+		counts[assignments[i]]++
+	}
+	means := make([][3]int, len(centers))
+	for i := range centers {
+		if counts[i] == 0 {
+			means[i] = centers[i]
+			continue
+		}
+		for c := 0; c < 3; c++ {
+			means[i][c] = (sums[i][c] + counts[i]/2) / counts[i]
+		}
+	}
+	return means
+}
@@ -0,0 +1,253 @@
+package transformations
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// maxOperations caps the length of an ops pipeline
+const maxOperations = 10
+
+// Operation is one step of an ops pipeline
+type Operation interface {
+	// Apply runs the operation on the image
+	Apply(img image.Image) (image.Image, error)
+	// Key is the operation's contribution to the cache key
+	Key() string
+	// Size is the size of the output for an input of the given size, so that
+	// operations are checked against the maximum output size before they run
+	Size(input image.Point) image.Point
+}
+
+// OperationParser validates the arguments of an operation, the text after
+// the colon in ops=name:args, and builds it
+type OperationParser func(args string) (Operation, error)
+
+var operations = map[string]OperationParser{}
+
+// makes an operation available to the ops parameter under name
+func RegisterOperation(name string, parser OperationParser) {
+	operations[name] = parser
+}
+
+func init() {
+	RegisterOperation("resize", parseSizeOperation("resize", resizeOperation))
+	RegisterOperation("crop", parseSizeOperation("crop", cropOperation))
+	RegisterOperation("rect", optionOperationParser("rect", false, extractRegion, regionVariant))
+	RegisterOperation("trim", optionOperationParser("trim", false, infallible(trim), trimVariant))
+	RegisterOperation("rotate", optionOperationParser("rotate", false, infallible(orient), orientationVariant))
+	RegisterOperation("flip", optionOperationParser("flip", false, infallible(orient), orientationVariant))
+	RegisterOperation("brightness", optionOperationParser("brightness", false, infallible(adjust), adjustmentsVariant))
+	RegisterOperation("contrast", optionOperationParser("contrast", false, infallible(adjust), adjustmentsVariant))
+	RegisterOperation("saturation", optionOperationParser("saturation", false, infallible(adjust), adjustmentsVariant))
+	RegisterOperation("gamma", optionOperationParser("gamma", false, infallible(adjust), adjustmentsVariant))
+	RegisterOperation("grayscale", optionOperationParser("grayscale", true, infallible(adjust), adjustmentsVariant))
+	RegisterOperation("blur", optionOperationParser("blur", false, infallible(applyEffectsOnly), effectsVariant))
+	RegisterOperation("sharpen", optionOperationParser("sharpen", false, infallible(applyEffectsOnly), effectsVariant))
+	RegisterOperation("pixelate", optionOperationParser("pixelate", false, infallible(applyEffectsOnly), effectsVariant))
+}
+
+// parses the ops query parameter, a | separated list of name:args operations
+// applied in order after the flat options and before watermarks, text and corners
+func parseOps(query func(string) string, options *Options) error {
+	opsQuery := query("ops")
+	if opsQuery == "" {
+		return nil
+	}
+
+	steps := strings.Split(opsQuery, "|")
+	if len(steps) > maxOperations {
+		return fmt.Errorf("invalid ops: at most %d operations are allowed", maxOperations)
+	}
+	for _, step := range steps {
+		name, args, _ := strings.Cut(step, ":")
+		parser, exists := operations[name]
+		if !exists {
+			return fmt.Errorf("invalid ops: unknown operation %s", name)
+		}
+		operation, err := parser(args)
+		if err != nil {
+			return fmt.Errorf("invalid ops: %s. %w", name, err)
+		}
+		options.Ops = append(options.Ops, operation)
+	}
+	return nil
+}
+
+func opsVariant(options *Options) []string {
+	if len(options.Ops) == 0 {
+		return nil
+	}
+	keys := make([]string, len(options.Ops))
+	for i, operation := range options.Ops {
+		keys[i] = operation.Key()
+	}
+	return []string{"ops-" + strings.Join(keys, "~")}
+}
+
+// runs the pipeline. A step may not grow the image past the maximum output
+// size on either side, which is checked before the step runs. Sources that are
+// already larger can still be processed by steps that do not grow them.
+func applyOps(img image.Image, options *Options) (image.Image, error) {
+	for _, operation := range options.Ops {
+		// Areas added by a step are filled depending on the output format
+		if step, isOption := operation.(*optionOperation); isOption {
+			step.options.Format = options.Format
+		}
+		input := img.Bounds().Size()
+		if err := checkOperationSize(operation, input, operation.Size(input)); err != nil {
+			return nil, err
+		}
+		var err error
+		img, err = operation.Apply(img)
+		if err != nil {
+			return nil, err
+		}
+		// Registered operations may misreport their size
+		if err := checkOperationSize(operation, input, img.Bounds().Size()); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+func checkOperationSize(operation Operation, input image.Point, output image.Point) error {
+	if (output.X > input.X && output.X > settings.MaxWidth) || (output.Y > input.Y && output.Y > settings.MaxHeight) {
+		return fmt.Errorf("ops output %dx%d after %s exceeds the maximum of %dx%d",
+			output.X, output.Y, operation.Key(), settings.MaxWidth, settings.MaxHeight)
+	}
+	return nil
+}
+
+// optionOperation runs one of the flat options as a pipeline step, reusing
+// its query parsing, apply function and cache key
+type optionOperation struct {
+	options Options
+	apply   func(image.Image, *Options) (image.Image, error)
+	variant func(*Options) []string
+}
+
+func (operation *optionOperation) Apply(img image.Image) (image.Image, error) {
+	return operation.apply(img, &operation.options)
+}
+
+func (operation *optionOperation) Key() string {
+	return strings.Join(operation.variant(&operation.options), "_")
+}
+
+// Of the flat options only rotate grows the image, the others keep its size
+// or shrink it
+func (operation *optionOperation) Size(input image.Point) image.Point {
+	return orientedSize(input, &operation.options)
+}
+
+// builds a parser that reads the operation arguments as the value of the
+// param query parameter. Flags take no arguments.
+func optionOperationParser(param string, flag bool, apply func(image.Image, *Options) (image.Image, error),
+	variant func(*Options) []string) OperationParser {
+	return func(args string) (Operation, error) {
+		if flag && args == "" {
+			args = "true"
+		}
+		if args == "" {
+			return nil, fmt.Errorf("missing arguments")
+		}
+
+		operation := &optionOperation{apply: apply, variant: variant}
+		query := func(name string) string {
+			if name == param {
+				return args
+			}
+			return ""
+		}
+		if err := parseQuery(query, nil, &operation.options); err != nil {
+			return nil, err
+		}
+		return operation, nil
+	}
+}
+
+func infallible(apply func(image.Image, *Options) image.Image) func(image.Image, *Options) (image.Image, error) {
+	return func(img image.Image, options *Options) (image.Image, error) {
+		return apply(img, options), nil
+	}
+}
+
+// applies the effects without automatic sharpening, which only makes sense after the main resize
+func applyEffectsOnly(img image.Image, options *Options) image.Image {
+	return applyEffects(img, options, 1)
+}
+
+// sizeOperation resizes or crops to a WxH size, either side may be left out
+type sizeOperation struct {
+	name   string
+	width  int
+	height int
+	apply  func(img image.Image, width int, height int) image.Image
+}
+
+func (operation *sizeOperation) Apply(img image.Image) (image.Image, error) {
+	width := operation.width
+	if width == 0 {
+		width = img.Bounds().Dx()
+	}
+	height := operation.height
+	if height == 0 {
+		height = img.Bounds().Dy()
+	}
+	return operation.apply(img, width, height), nil
+}
+
+// Neither resize nor crop enlarge the image
+func (operation *sizeOperation) Size(input image.Point) image.Point {
+	size := input
+	if operation.width != 0 {
+		size.X = min(size.X, operation.width)
+	}
+	if operation.height != 0 {
+		size.Y = min(size.Y, operation.height)
+	}
+	return size
+}
+
+func (operation *sizeOperation) Key() string {
+	return fmt.Sprintf("%s-%dx%d", operation.name, operation.width, operation.height)
+}
+
+func resizeOperation(img image.Image, width int, height int) image.Image {
+	// The filter is fixed so the operation key fully describes the output
+	return imaging.Fit(img, width, height, imaging.Lanczos)
+}
+
+func cropOperation(img image.Image, width int, height int) image.Image {
+	return imaging.CropCenter(img, width, height)
+}
+
+func parseSizeOperation(name string, apply func(img image.Image, width int, height int) image.Image) OperationParser {
+	return func(args string) (Operation, error) {
+		widthArg, heightArg, found := strings.Cut(args, "x")
+		if !found {
+			return nil, fmt.Errorf("expected WxH, got %s", args)
+		}
+		operation := &sizeOperation{name: name, apply: apply}
+		var err error
+		if widthArg != "" {
+			if operation.width, err = strconv.Atoi(widthArg); err != nil || operation.width <= 0 || operation.width > settings.MaxWidth {
+				return nil, fmt.Errorf("invalid width: %s", widthArg)
+			}
+		}
+		if heightArg != "" {
+			if operation.height, err = strconv.Atoi(heightArg); err != nil || operation.height <= 0 || operation.height > settings.MaxHeight {
+				return nil, fmt.Errorf("invalid height: %s", heightArg)
+			}
+		}
+		if operation.width == 0 && operation.height == 0 {
+			return nil, fmt.Errorf("expected WxH, got %s", args)
+		}
+		return operation, nil
+	}
+}
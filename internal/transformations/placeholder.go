@@ -0,0 +1,244 @@
+package transformations
+
+import (
+	"encoding/base64"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// Placeholder formats, compact text hashes of the image instead of an image
+const (
+	BlurHash  = "blurhash"
+	ThumbHash = "thumbhash"
+)
+
+// Components of the blurhash, 4 across and 3 down
+const (
+	blurHashComponentsX = 4
+	blurHashComponentsY = 3
+)
+
+func isPlaceholderFormat(format string) bool {
+	return format == BlurHash || format == ThumbHash
+}
+
+// encodes the image as a blurhash or base64 thumbhash, computed from a small downscale
+func encodePlaceholder(img image.Image, format string) string {
+	if format == BlurHash {
+		return blurHash(imaging.Fit(img, 32, 32, imaging.Box), blurHashComponentsX, blurHashComponentsY)
+	}
+	// ThumbHash is only defined for images up to 100x100
+	return base64.StdEncoding.EncodeToString(thumbHash(imaging.Fit(img, 100, 100, imaging.Box)))
+}
+
+const base83Characters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+func base83(value int, length int) string {
+	encoded := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		encoded[i] = base83Characters[value%83]
+		value /= 83
+	}
+	return string(encoded)
+}
+
+func srgbToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSrgb(value float64) int {
+	v := min(max(value, 0), 1)
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value float64, exponent float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exponent), value)
+}
+
+// implements the blurhash encoder, https://github.com/woltapp/blurhash
+func blurHash(img image.Image, componentsX int, componentsY int) string {
+	src := imaging.Clone(img)
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+
+	factors := make([][3]float64, 0, componentsX*componentsY)
+	for j := 0; j < componentsY; j++ {
+		for i := 0; i < componentsX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := math.Cos(math.Pi*float64(i*x)/float64(width)) * math.Cos(math.Pi*float64(j*y)/float64(height))
+					pixel := src.NRGBAAt(x, y)
+					factor[0] += basis * srgbToLinear(pixel.R)
+					factor[1] += basis * srgbToLinear(pixel.G)
+					factor[2] += basis * srgbToLinear(pixel.B)
+				}
+			}
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	dc, ac := factors[0], factors[1:]
+	hash := base83((componentsX-1)+(componentsY-1)*9, 1)
+
+	maximumValue := 1.0
+	if len(ac) > 0 {
+		actualMaximum := 0.0
+		for _, factor := range ac {
+			actualMaximum = max(actualMaximum, math.Abs(factor[0]), math.Abs(factor[1]), math.Abs(factor[2]))
+		}
+		quantisedMaximum := int(max(0, min(82, math.Floor(actualMaximum*166-0.5))))
+		maximumValue = float64(quantisedMaximum+1) / 166
+		hash += base83(quantisedMaximum, 1)
+	} else {
+		hash += base83(0, 1)
+	}
+
+	hash += base83(linearToSrgb(dc[0])<<16+linearToSrgb(dc[1])<<8+linearToSrgb(dc[2]), 4)
+	quantise := func(value float64) int {
+		return int(max(0, min(18, math.Floor(signPow(value/maximumValue, 0.5)*9+9.5))))
+	}
+	for _, factor := range ac {
+		hash += base83(quantise(factor[0])*19*19+quantise(factor[1])*19+quantise(factor[2]), 2)
+	}
+	return hash
+}
+
+// implements the ThumbHash encoder, https://github.com/evanw/thumbhash
+func thumbHash(img image.Image) []byte {
+	src := imaging.Clone(img)
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	pixels := width * height
+	round := func(value float64) int {
+		return int(math.Floor(value + 0.5))
+	}
+
+	// Determine the average color
+	var averageR, averageG, averageB, averageA float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pixel := src.NRGBAAt(x, y)
+			alpha := float64(pixel.A) / 255
+			averageR += alpha / 255 * float64(pixel.R)
+			averageG += alpha / 255 * float64(pixel.G)
+			averageB += alpha / 255 * float64(pixel.B)
+			averageA += alpha
+		}
+	}
+	if averageA > 0 {
+		averageR /= averageA
+		averageG /= averageA
+		averageB /= averageA
+	}
+
+	hasAlpha := averageA < float64(pixels)
+	// Fewer luminance components when there is alpha
+	luminanceLimit := 7.0
+	if hasAlpha {
+		luminanceLimit = 5
+	}
+	longest := float64(max(width, height))
+	lx := max(1, round(luminanceLimit*float64(width)/longest))
+	ly := max(1, round(luminanceLimit*float64(height)/longest))
+
+	// Convert to luminance, yellow-blue, red-green and alpha, composited atop the average color
+	l := make([]float64, pixels)
+	p := make([]float64, pixels)
+	q := make([]float64, pixels)
+	a := make([]float64, pixels)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pixel := src.NRGBAAt(x, y)
+			alpha := float64(pixel.A) / 255
+			r := averageR*(1-alpha) + alpha/255*float64(pixel.R)
+			g := averageG*(1-alpha) + alpha/255*float64(pixel.G)
+			b := averageB*(1-alpha) + alpha/255*float64(pixel.B)
+			i := x + y*width
+			l[i] = (r + g + b) / 3
+			p[i] = (r+g)/2 - b
+			q[i] = r - g
+			a[i] = alpha
+		}
+	}
+
+	// Encode with the DCT into the constant term and normalised varying terms
+	encodeChannel := func(channel []float64, nx int, ny int) (float64, []float64, float64) {
+		var dc, scale float64
+		var ac []float64
+		fx := make([]float64, width)
+		for cy := 0; cy < ny; cy++ {
+			for cx := 0; cx*ny < nx*(ny-cy); cx++ {
+				for x := 0; x < width; x++ {
+					fx[x] = math.Cos(math.Pi / float64(width) * float64(cx) * (float64(x) + 0.5))
+				}
+				f := 0.0
+				for y := 0; y < height; y++ {
+					fy := math.Cos(math.Pi / float64(height) * float64(cy) * (float64(y) + 0.5))
+					for x := 0; x < width; x++ {
+						f += channel[x+y*width] * fx[x] * fy
+					}
+				}
+				f /= float64(pixels)
+				if cx > 0 || cy > 0 {
+					ac = append(ac, f)
+					scale = max(scale, math.Abs(f))
+				} else {
+					dc = f
+				}
+			}
+		}
+		if scale > 0 {
+			for i := range ac {
+				ac[i] = 0.5 + 0.5/scale*ac[i]
+			}
+		}
+		return dc, ac, scale
+	}
+	lDC, lAC, lScale := encodeChannel(l, max(3, lx), max(3, ly))
+	pDC, pAC, pScale := encodeChannel(p, 3, 3)
+	qDC, qAC, qScale := encodeChannel(q, 3, 3)
+
+	// Write the constants
+	isLandscape := width > height
+	header24 := round(63*lDC) | round(31.5+31.5*pDC)<<6 | round(31.5+31.5*qDC)<<12 | round(31*lScale)<<18
+	header16 := lx | round(63*pScale)<<3 | round(63*qScale)<<9
+	if isLandscape {
+		header16 = ly | round(63*pScale)<<3 | round(63*qScale)<<9 | 1<<15
+	}
+	channels := [][]float64{lAC, pAC, qAC}
+	if hasAlpha {
+		header24 |= 1 << 23
+	}
+	hash := []byte{byte(header24), byte(header24 >> 8), byte(header24 >> 16), byte(header16), byte(header16 >> 8)}
+	if hasAlpha {
+		aDC, aAC, aScale := encodeChannel(a, 5, 5)
+		hash = append(hash, byte(round(15*aDC)|round(15*aScale)<<4))
+		channels = append(channels, aAC)
+	}
+
+	// Write the varying factors, two per byte
+	index := 0
+	for _, ac := range channels {
+		for _, f := range ac {
+			if index%2 == 0 {
+				hash = append(hash, 0)
+			}
+			hash[len(hash)-1] |= byte(round(15*f) << ((index % 2) * 4))
+			index++
+		}
+	}
+	return hash
+}
@@ -0,0 +1,73 @@
+package transformations
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Preset is a named set of query parameters, e.g. {"width": "320", "mode": "crop"}
+type Preset map[string]string
+
+var presets = map[string]Preset{}
+
+// reads the presets from a JSON file mapping preset names to their parameters
+func LoadPresets(configPath string) error {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read presets: %w", err)
+	}
+
+	loaded := map[string]Preset{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("failed to parse presets: %w", err)
+	}
+
+	// Catch configuration mistakes at startup instead of on the first request
+	for name, preset := range loaded {
+		options := Options{Mode: Fit, Format: "jpg"}
+		if err := parseQuery(preset.get, nil, &options); err != nil {
+			return fmt.Errorf("invalid preset %s: %w", name, err)
+		}
+	}
+
+	presets = loaded
+	return nil
+}
+
+// reports whether requests are limited to the presets, see Settings.PresetsOnly
+func PresetsOnly() bool {
+	return settings.PresetsOnly
+}
+
+func (preset Preset) get(name string) string {
+	return preset[name]
+}
+
+// resolves the preset query parameter. Parameters in the request override the
+// preset, except in presets-only mode where a preset is required and every
+// other parameter is ignored.
+func applyPreset(query func(string) string) (func(string) string, error) {
+	name := query("preset")
+	if name == "" {
+		if settings.PresetsOnly {
+			return nil, fmt.Errorf("a preset is required")
+		}
+		return query, nil
+	}
+
+	preset, exists := presets[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown preset: %s", name)
+	}
+	if settings.PresetsOnly {
+		return preset.get, nil
+	}
+
+	return func(param string) string {
+		if value := query(param); value != "" {
+			return value
+		}
+		return preset[param]
+	}, nil
+}
@@ -0,0 +1,104 @@
+package transformations
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// MaxRadius rounds the corners as far as possible, a circle for square images
+const MaxRadius = -1
+
+func isJPEG(format string) bool {
+	format = strings.ToLower(format)
+	return format == "jpg" || format == "jpeg"
+}
+
+// parses the radius query parameter. JPEG has no alpha channel so rounded
+// JPEGs need a bg color for the corners, without one the output becomes PNG.
+func parseRadius(query func(string) string, options *Options) error {
+	radiusQuery := query("radius")
+	if radiusQuery == "" {
+		return nil
+	}
+
+	if radiusQuery == "max" {
+		options.Radius = MaxRadius
+	} else {
+		radius, err := strconv.Atoi(radiusQuery)
+		if err != nil || radius < 0 || radius > max(settings.MaxWidth, settings.MaxHeight) {
+			return fmt.Errorf("invalid radius: %s", radiusQuery)
+		}
+		options.Radius = radius
+	}
+
+	if options.Radius != 0 && isJPEG(options.Format) && options.Background == "" {
+		options.Format = "png"
+	}
+	return nil
+}
+
+func radiusVariant(options *Options) []string {
+	switch options.Radius {
+	case 0:
+		return nil
+	case MaxRadius:
+		return []string{"radius-max"}
+	default:
+		return []string{"radius-" + strconv.Itoa(options.Radius)}
+	}
+}
+
+// masks the corners of the image with an anti-aliased quarter circle
+func roundCorners(img image.Image, options *Options) image.Image {
+	if options.Radius == 0 {
+		return img
+	}
+
+	result := imaging.Clone(img)
+	width := result.Bounds().Dx()
+	height := result.Bounds().Dy()
+	radius := float64(min(width, height)) / 2
+	if options.Radius != MaxRadius {
+		radius = min(radius, float64(options.Radius))
+	}
+
+	corner := int(math.Ceil(radius))
+	for y := 0; y < height; y++ {
+		// Distance from the centre of the nearest corner arc, zero outside the corner squares
+		dy := 0.0
+		if y < corner {
+			dy = radius - (float64(y) + 0.5)
+		} else if y >= height-corner {
+			dy = (float64(y) + 0.5) - (float64(height) - radius)
+		}
+		if dy <= 0 {
+			continue
+		}
+		for x := 0; x < width; x++ {
+			dx := 0.0
+			if x < corner {
+				dx = radius - (float64(x) + 0.5)
+			} else if x >= width-corner {
+				dx = (float64(x) + 0.5) - (float64(width) - radius)
+			}
+			if dx <= 0 {
+				continue
+			}
+
+			coverage := math.Max(0, math.Min(1, radius-math.Hypot(dx, dy)+0.5))
+			alpha := &result.Pix[y*result.Stride+x*4+3]
+			*alpha = uint8(math.Round(float64(*alpha) * coverage))
+		}
+	}
+
+	if isJPEG(options.Format) {
+		background := imaging.New(width, height, options.backgroundColor())
+		return imaging.Overlay(background, result, image.Point{}, 1)
+	}
+	return result
+}
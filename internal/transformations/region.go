@@ -0,0 +1,93 @@
+package transformations
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// Region is a rectangle of the source image. When Fractional is set the
+// coordinates are fractions of the source size, otherwise pixels.
+type Region struct {
+	X, Y, Width, Height float64
+	Fractional          bool
+}
+
+// parses the rect=x,y,w,h query parameter, in pixels or, with a pct: prefix,
+// in percent of the source size, e.g. rect=pct:25,0,50,100
+func parseRegion(query func(string) string, options *Options) error {
+	rectQuery := query("rect")
+	if rectQuery == "" {
+		return nil
+	}
+
+	values, fractional := strings.CutPrefix(rectQuery, "pct:")
+	numbers := strings.Split(values, ",")
+	if len(numbers) != 4 {
+		return fmt.Errorf("invalid rect: %s. expected x,y,w,h or pct:x,y,w,h", rectQuery)
+	}
+	var parsed [4]float64
+	for i, value := range numbers {
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) || number < 0 {
+			return fmt.Errorf("invalid rect: %s", rectQuery)
+		}
+		if fractional {
+			if number > 100 {
+				return fmt.Errorf("invalid rect: %s. percentages must be at most 100", rectQuery)
+			}
+			number /= 100
+		} else if number != math.Trunc(number) {
+			return fmt.Errorf("invalid rect: %s. pixel coordinates must be whole numbers", rectQuery)
+		}
+		parsed[i] = number
+	}
+	if parsed[2] == 0 || parsed[3] == 0 {
+		return fmt.Errorf("invalid rect: %s. width and height must be positive", rectQuery)
+	}
+
+	options.Region = &Region{X: parsed[0], Y: parsed[1], Width: parsed[2], Height: parsed[3], Fractional: fractional}
+	return nil
+}
+
+func regionVariant(options *Options) []string {
+	if options.Region == nil {
+		return nil
+	}
+	region := options.Region
+	part := fmt.Sprintf("rect-%s-%s-%s-%s", formatFloat(region.X), formatFloat(region.Y),
+		formatFloat(region.Width), formatFloat(region.Height))
+	if region.Fractional {
+		part += "-f"
+	}
+	return []string{part}
+}
+
+// returns the region in pixels of bounds, clamped so it stays inside them
+func (region *Region) rectangle(bounds image.Rectangle) image.Rectangle {
+	x, y, width, height := region.X, region.Y, region.Width, region.Height
+	if region.Fractional {
+		x *= float64(bounds.Dx())
+		width *= float64(bounds.Dx())
+		y *= float64(bounds.Dy())
+		height *= float64(bounds.Dy())
+	}
+	rect := image.Rect(int(math.Round(x)), int(math.Round(y)), int(math.Round(x+width)), int(math.Round(y+height)))
+	return rect.Add(bounds.Min).Intersect(bounds)
+}
+
+// extracts the region from the source image, before any other operation
+func extractRegion(img image.Image, options *Options) (image.Image, error) {
+	if options.Region == nil {
+		return img, nil
+	}
+	rect := options.Region.rectangle(img.Bounds())
+	if rect.Empty() {
+		return nil, fmt.Errorf("rect is outside of the %dx%d image", img.Bounds().Dx(), img.Bounds().Dy())
+	}
+	return imaging.Crop(img, rect), nil
+}
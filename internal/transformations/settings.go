@@ -0,0 +1,172 @@
+package transformations
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Settings holds the server-side limits and defaults applied to every request
+type Settings struct {
+	// MaxDPR caps the device pixel ratio a client can ask for
+	MaxDPR float32
+	// ClientHints enables the DPR and Width client hint headers
+	ClientHints bool
+	// MaxWidth and MaxHeight cap the requested output dimensions
+	MaxWidth  int
+	MaxHeight int
+	// DefaultFilter is the resampling filter used when a request does not pick one
+	DefaultFilter string
+	// FastDownscaleRatio is the downscale ratio above which the image is
+	// shrunk with a box filter first, zero disables it
+	FastDownscaleRatio float64
+	// AutoSharpenRatio is the downscale ratio above which outputs are
+	// sharpened with AutoSharpenSigma unless the request sets its own, zero disables it
+	AutoSharpenRatio float64
+	AutoSharpenSigma float64
+	// PresetsOnly rejects requests without a preset and ignores every other parameter
+	PresetsOnly bool
+}
+
+var settings = DefaultSettings()
+
+func DefaultSettings() Settings {
+	return Settings{
+		MaxDPR:             3,
+		MaxWidth:           4096,
+		MaxHeight:          4096,
+		DefaultFilter:      Lanczos,
+		FastDownscaleRatio: 4,
+		AutoSharpenSigma:   0.5,
+	}
+}
+
+// reads the server settings from environment variables, falling back to the defaults
+func LoadSettings() error {
+	loaded := DefaultSettings()
+
+	maxDPR, err := envFloat("MAX_DPR", loaded.MaxDPR)
+	if err != nil {
+		return err
+	}
+	if maxDPR < 1 {
+		return fmt.Errorf("invalid MAX_DPR: %v", maxDPR)
+	}
+	loaded.MaxDPR = maxDPR
+
+	loaded.ClientHints, err = envBool("CLIENT_HINTS", loaded.ClientHints)
+	if err != nil {
+		return err
+	}
+
+	loaded.MaxWidth, err = envInt("MAX_OUTPUT_WIDTH", loaded.MaxWidth)
+	if err != nil {
+		return err
+	}
+	loaded.MaxHeight, err = envInt("MAX_OUTPUT_HEIGHT", loaded.MaxHeight)
+	if err != nil {
+		return err
+	}
+	if loaded.MaxWidth <= 0 || loaded.MaxHeight <= 0 {
+		return fmt.Errorf("invalid maximum output size: %dx%d", loaded.MaxWidth, loaded.MaxHeight)
+	}
+
+	if filter := os.Getenv("DEFAULT_FILTER"); filter != "" {
+		if !validateFilter(filter) {
+			return fmt.Errorf("invalid DEFAULT_FILTER: %s", filter)
+		}
+		loaded.DefaultFilter = filter
+	}
+
+	fastDownscaleRatio, err := envFloat("FAST_DOWNSCALE_RATIO", float32(loaded.FastDownscaleRatio))
+	if err != nil {
+		return err
+	}
+	// The box filter shrinks to twice the target size, so lower ratios would enlarge the image
+	if fastDownscaleRatio != 0 && fastDownscaleRatio < 2 {
+		return fmt.Errorf("invalid FAST_DOWNSCALE_RATIO: %v, expected 0 or at least 2", fastDownscaleRatio)
+	}
+	loaded.FastDownscaleRatio = float64(fastDownscaleRatio)
+
+	autoSharpenRatio, err := envFloat("AUTO_SHARPEN_RATIO", float32(loaded.AutoSharpenRatio))
+	if err != nil {
+		return err
+	}
+	// Ratios below 1 are upscales
+	if autoSharpenRatio != 0 && autoSharpenRatio < 1 {
+		return fmt.Errorf("invalid AUTO_SHARPEN_RATIO: %v, expected 0 or at least 1", autoSharpenRatio)
+	}
+	loaded.AutoSharpenRatio = float64(autoSharpenRatio)
+	autoSharpenSigma, err := envFloat("AUTO_SHARPEN_SIGMA", float32(loaded.AutoSharpenSigma))
+	if err != nil {
+		return err
+	}
+	if autoSharpenSigma <= 0 || autoSharpenSigma > maxSharpenSigma {
+		return fmt.Errorf("invalid AUTO_SHARPEN_SIGMA: %v", autoSharpenSigma)
+	}
+	loaded.AutoSharpenSigma = float64(autoSharpenSigma)
+
+	if watermarksFile := os.Getenv("WATERMARKS_FILE"); watermarksFile != "" {
+		if err := LoadWatermarks(watermarksFile); err != nil {
+			return err
+		}
+	}
+
+	if presetsFile := os.Getenv("PRESETS_FILE"); presetsFile != "" {
+		// Presets are validated against the loaded settings, which are only
+		// kept once the presets loaded
+		previous := settings
+		settings = loaded
+		err := LoadPresets(presetsFile)
+		settings = previous
+		if err != nil {
+			return err
+		}
+	}
+	loaded.PresetsOnly, err = envBool("PRESETS_ONLY", loaded.PresetsOnly)
+	if err != nil {
+		return err
+	}
+	if loaded.PresetsOnly && len(presets) == 0 {
+		return fmt.Errorf("PRESETS_ONLY is set but no presets are loaded")
+	}
+
+	settings = loaded
+	return nil
+}
+
+func envInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s. %s", name, value, err)
+	}
+	return parsed, nil
+}
+
+func envFloat(name string, fallback float32) (float32, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s. %s", name, value, err)
+	}
+	return float32(parsed), nil
+}
+
+func envBool(name string, fallback bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %s. %s", name, value, err)
+	}
+	return parsed, nil
+}
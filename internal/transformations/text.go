@@ -0,0 +1,236 @@
+package transformations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomedium"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Limits on the caption so rendering stays cheap
+const (
+	maxTextLength = 200
+	minTextSize   = 6
+	maxTextSize   = 200
+)
+
+// Bundled fonts, rasterized in pure Go so no system fonts are needed
+var fonts = map[string][]byte{
+	"regular":    goregular.TTF,
+	"bold":       gobold.TTF,
+	"italic":     goitalic.TTF,
+	"bolditalic": gobolditalic.TTF,
+	"medium":     gomedium.TTF,
+	"mono":       gomono.TTF,
+	"monobold":   gomonobold.TTF,
+}
+
+var (
+	parsedFonts   = map[string]*opentype.Font{}
+	parsedFontsMu sync.Mutex
+)
+
+// TextOptions is a caption rendered onto the output
+type TextOptions struct {
+	Text    string
+	Font    string
+	Size    float64
+	Color   string
+	Gravity string
+	// MaxWidth is the width in pixels the text wraps at, zero uses the output width
+	MaxWidth int
+	// Background is the color of the band drawn behind the text, empty for none
+	Background string
+}
+
+func loadFont(name string) (*opentype.Font, error) {
+	parsedFontsMu.Lock()
+	defer parsedFontsMu.Unlock()
+
+	if parsed, exists := parsedFonts[name]; exists {
+		return parsed, nil
+	}
+	parsed, err := opentype.Parse(fonts[name])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse font %s: %w", name, err)
+	}
+	parsedFonts[name] = parsed
+	return parsed, nil
+}
+
+// parses the text, text_font, text_size, text_color, text_gravity, text_width and text_bg query parameters
+func parseText(query func(string) string, options *Options) error {
+	text := query("text")
+	if text == "" {
+		return nil
+	}
+	if !utf8.ValidString(text) || utf8.RuneCountInString(text) > maxTextLength {
+		return fmt.Errorf("invalid text: must be at most %d characters", maxTextLength)
+	}
+
+	options.Text = TextOptions{
+		Text:    text,
+		Font:    "regular",
+		Size:    24,
+		Color:   "ffffffff",
+		Gravity: South,
+	}
+
+	if fontQuery := query("text_font"); fontQuery != "" {
+		if _, exists := fonts[fontQuery]; !exists {
+			return fmt.Errorf("invalid text_font: %s", fontQuery)
+		}
+		options.Text.Font = fontQuery
+	}
+
+	if sizeQuery := query("text_size"); sizeQuery != "" {
+		size, err := parseFloatRange(query, "text_size", minTextSize, maxTextSize)
+		if err != nil {
+			return err
+		}
+		options.Text.Size = size
+	}
+
+	if colorQuery := query("text_color"); colorQuery != "" {
+		textColor, err := ParseColor(colorQuery)
+		if err != nil {
+			return fmt.Errorf("invalid text_color: %s", colorQuery)
+		}
+		options.Text.Color = FormatColor(textColor)
+	}
+
+	if gravityQuery := query("text_gravity"); gravityQuery != "" {
+		if !validateGravity(gravityQuery) {
+			return fmt.Errorf("invalid text_gravity: %s", gravityQuery)
+		}
+		options.Text.Gravity = gravityQuery
+	}
+
+	if widthQuery := query("text_width"); widthQuery != "" {
+		width, err := strconv.Atoi(widthQuery)
+		if err != nil || width <= 0 || width > settings.MaxWidth {
+			return fmt.Errorf("invalid text_width: %s", widthQuery)
+		}
+		options.Text.MaxWidth = width
+	}
+
+	if bgQuery := query("text_bg"); bgQuery != "" {
+		background, err := ParseColor(bgQuery)
+		if err != nil {
+			return fmt.Errorf("invalid text_bg: %s", bgQuery)
+		}
+		options.Text.Background = FormatColor(background)
+	}
+
+	return nil
+}
+
+func textVariant(options *Options) []string {
+	if options.Text.Text == "" {
+		return nil
+	}
+	// The caption itself can hold any character so only its hash goes in the key
+	sum := sha256.Sum256([]byte(options.Text.Text))
+	part := fmt.Sprintf("text-%s-%s-%s-%s-%s-%d", hex.EncodeToString(sum[:6]), options.Text.Font,
+		formatFloat(options.Text.Size), options.Text.Color, options.Text.Gravity, options.Text.MaxWidth)
+	if options.Text.Background != "" {
+		part += "-" + options.Text.Background
+	}
+	return []string{part}
+}
+
+// renders the caption, word wrapped at the max width, over an optional full-width band
+func applyText(img image.Image, options *Options) (image.Image, error) {
+	if options.Text.Text == "" {
+		return img, nil
+	}
+	parsed, err := loadFont(options.Text.Font)
+	if err != nil {
+		return nil, err
+	}
+	face, err := opentype.NewFace(parsed, &opentype.FaceOptions{Size: options.Text.Size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load font face: %w", err)
+	}
+	defer face.Close()
+
+	result := imaging.Clone(img)
+	canvas := result.Bounds()
+	padding := int(options.Text.Size / 2)
+
+	maxWidth := canvas.Dx() - 2*padding
+	if options.Text.MaxWidth > 0 {
+		maxWidth = min(maxWidth, options.Text.MaxWidth)
+	}
+	lines := wrapText(face, options.Text.Text, fixed.I(max(1, maxWidth)))
+
+	lineHeight := face.Metrics().Height.Ceil()
+	blockWidth := 0
+	for _, line := range lines {
+		blockWidth = max(blockWidth, font.MeasureString(face, line).Ceil())
+	}
+	block := image.Rectangle{Max: image.Pt(blockWidth, lineHeight*len(lines))}
+	block = block.Add(gravityPosition(options.Text.Gravity, canvas, block.Size(), padding))
+
+	if options.Text.Background != "" {
+		band := image.Rect(canvas.Min.X, block.Min.Y-padding, canvas.Max.X, block.Max.Y+padding)
+		background, _ := ParseColor(options.Text.Background)
+		draw.Draw(result, band, image.NewUniform(background), image.Point{}, draw.Over)
+	}
+
+	textColor, _ := ParseColor(options.Text.Color)
+	drawer := font.Drawer{Dst: result, Src: image.NewUniform(textColor), Face: face}
+	for i, line := range lines {
+		lineWidth := font.MeasureString(face, line).Ceil()
+		x := block.Min.X + (blockWidth-lineWidth)/2
+		switch options.Text.Gravity {
+		case West, NorthWest, SouthWest:
+			x = block.Min.X
+		case East, NorthEast, SouthEast:
+			x = block.Max.X - lineWidth
+		}
+		drawer.Dot = fixed.P(x, block.Min.Y+i*lineHeight+face.Metrics().Ascent.Ceil())
+		drawer.DrawString(line)
+	}
+
+	return result, nil
+}
+
+// splits text into lines no wider than maxWidth, a single word wider than
+// maxWidth is kept on its own line rather than broken
+func wrapText(face font.Face, text string, maxWidth fixed.Int26_6) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if line != "" && font.MeasureString(face, candidate) > maxWidth {
+				lines = append(lines, line)
+				candidate = word
+			}
+			line = candidate
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
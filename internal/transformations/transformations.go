@@ -2,8 +2,12 @@ package transformations
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"image"
+	"image/color"
+	"math"
 	"net/url"
 	"path"
 	"strconv"
@@ -21,13 +25,71 @@ type Options struct {
 	Mode        string
 	Quality     int
 	Format      string
+	// Enlarge allows the output to be larger than the source image
+	Enlarge bool
+	// Filter is the resampling filter used when resizing
+	Filter string
+	// Rotate is the clockwise rotation in degrees
+	Rotate float64
+	// Flip mirrors the image horizontally, vertically or both
+	Flip string
+	// Background is the hex color used to fill uncovered areas, or DominantBackground
+	Background string
+	// Color adjustments, zero leaves the image unchanged
+	Brightness float64
+	Contrast   float64
+	Saturation float64
+	Gamma      float64
+	Grayscale  bool
+	// Blur and Sharpen are gaussian sigmas, SharpenAmount scales the sharpening
+	Blur          float64
+	Sharpen       float64
+	SharpenAmount float64
+	// Pixelate is the block size in pixels
+	Pixelate int
+	// Watermark is the server-configured overlay composited on the output
+	Watermark WatermarkOptions
+	// Text is the caption rendered onto the output
+	Text TextOptions
+	// Radius rounds the corners of the output in pixels, MaxRadius for a circle
+	Radius int
+	// Region is extracted from the source before the other operations, nil for the whole image
+	Region *Region
+	// Trim crops uniform borders off the source, nil to keep them
+	Trim *TrimOptions
+	// Ops is an ordered pipeline run after the operations above
+	Ops []Operation
+	// AutoFormat is set when Format was negotiated from the Accept header
+	AutoFormat bool
+
+	// dominant is the resolved color for Background DominantBackground
+	dominant color.NRGBA
 }
 
 const (
 	Crop = "crop"
 	Fit  = "fit"
+	// Fill scales the image to cover the size and crops the overflow
+	Fill = "fill"
+	// Pad fits the image within the size and fills the rest with the background
+	Pad = "pad"
 )
 
+const Lanczos = "lanczos"
+
+// maxVariantLength is the longest variant kept readable in cache keys, well
+// below the common 255 byte file name limit
+const maxVariantLength = 200
+
+// Resampling filter mappings
+var filters = map[string]imaging.ResampleFilter{
+	"nearest":     imaging.NearestNeighbor,
+	"linear":      imaging.Linear,
+	"catmull-rom": imaging.CatmullRom,
+	Lanczos:       imaging.Lanczos,
+	"box":         imaging.Box,
+}
+
 // Aspect ratio mappings
 var aspectRatios = map[string]float32{
 	"16x9": 16.0 / 9.0,
@@ -48,6 +110,11 @@ func validateFormat(extension string) bool {
 	return validExtensions[extension]
 }
 
+// reports whether format is an image format TransformImage can encode
+func ValidFormat(format string) bool {
+	return validateFormat(format)
+}
+
 func FormatFromPath(imgURL string) (string, error) {
 	// Parse the URL
 	parsedURL, err := url.Parse(imgURL)
@@ -69,10 +136,24 @@ func validateMode(mode string) bool {
 	validModes := map[string]bool{
 		"fit":  true,
 		"crop": true,
+		"fill": true,
+		"pad":  true,
 	}
 	return validModes[mode]
 }
 
+func validateFilter(filter string) bool {
+	_, exists := filters[filter]
+	return exists
+}
+
+func resampleFilter(filter string) imaging.ResampleFilter {
+	if resample, exists := filters[filter]; exists {
+		return resample
+	}
+	return imaging.Lanczos
+}
+
 func AspectRatioToFloat(aspectRatio string) (float32, bool) {
 	ratio, exists := aspectRatios[aspectRatio]
 	return ratio, exists
@@ -97,7 +178,7 @@ func ParseOptions(widthQuery string, heightQuery string, formatQuery string, mod
 	}
 
 	if formatQuery != "" {
-		if !validateFormat(formatQuery) {
+		if !validateFormat(formatQuery) && !isPlaceholderFormat(formatQuery) {
 			return fmt.Errorf("invalid extension: %s", formatQuery)
 		}
 		options.Format = formatQuery
@@ -128,20 +209,255 @@ func ParseOptions(widthQuery string, heightQuery string, formatQuery string, mod
 	return nil
 }
 
+// parses the options from the query parameters of a request, after resolving
+// any preset. Client hint headers are only read when they are enabled in the
+// server settings.
+func ParseQuery(query func(string) string, header func(string) string, options *Options) error {
+	query, err := applyPreset(query)
+	if err != nil {
+		return err
+	}
+	// Hints would let clients vary the output outside of the presets, only the
+	// Accept header is kept for presets with format=auto
+	if settings.PresetsOnly && header != nil {
+		requestHeader := header
+		header = func(name string) string {
+			if name == "Accept" {
+				return requestHeader(name)
+			}
+			return ""
+		}
+	}
+	return parseQuery(query, header, options)
+}
+
+func parseQuery(query func(string) string, header func(string) string, options *Options) error {
+	widthQuery := query("width")
+	heightQuery := query("height")
+	formatQuery := query("format")
+	if formatQuery == "auto" {
+		formatQuery = negotiateFormat(header, options.Format)
+		options.AutoFormat = true
+	}
+	err := ParseOptions(widthQuery, heightQuery, formatQuery, query("mode"),
+		query("quality"), query("ratio"), options)
+	if err != nil {
+		return err
+	}
+
+	dpr := float32(1)
+	if dprQuery := query("dpr"); dprQuery != "" {
+		dpr, err = parseDPR(dprQuery)
+		if err != nil {
+			return err
+		}
+	} else if settings.ClientHints {
+		// Malformed hints are ignored rather than failing the request
+		if hint, hintErr := parseDPR(clientHint(header, "Sec-CH-DPR", "DPR")); hintErr == nil {
+			dpr = hint
+		}
+	}
+
+	// The Width hint is already in physical pixels so the DPR is not applied to it
+	if widthQuery == "" && heightQuery == "" && settings.ClientHints {
+		if hint, hintErr := strconv.Atoi(clientHint(header, "Sec-CH-Width", "Width")); hintErr == nil && hint > 0 {
+			// The hint is not chosen by the client so it is clamped instead of rejected
+			options.Width = min(hint, settings.MaxWidth)
+			dpr = 1
+		}
+	}
+
+	options.Width = scaleDimension(options.Width, dpr)
+	options.Height = scaleDimension(options.Height, dpr)
+	if options.Width < 0 || options.Height < 0 {
+		return fmt.Errorf("invalid size %dx%d, width and height must not be negative", options.Width, options.Height)
+	}
+	// The side implied by the ratio is only resolved in TransformImage, so it
+	// is checked here without changing the options and their cache key
+	width, height := ratioSize(options.Width, options.Height, options.AspectRatio)
+	if width > settings.MaxWidth || height > settings.MaxHeight {
+		return fmt.Errorf("requested size %dx%d exceeds the maximum of %dx%d",
+			width, height, settings.MaxWidth, settings.MaxHeight)
+	}
+
+	options.Filter = settings.DefaultFilter
+	if filterQuery := query("filter"); filterQuery != "" {
+		if !validateFilter(filterQuery) {
+			return fmt.Errorf("invalid filter: %s", filterQuery)
+		}
+		options.Filter = filterQuery
+	}
+
+	if enlargeQuery := query("enlarge"); enlargeQuery != "" {
+		options.Enlarge, err = strconv.ParseBool(enlargeQuery)
+		if err != nil {
+			return fmt.Errorf("invalid enlarge: %s", enlargeQuery)
+		}
+	}
+
+	parsers := []func(func(string) string, *Options) error{
+		parseRegion,
+		parseTrim,
+		parseOrientation,
+		parseAdjustments,
+		parseEffects,
+		parseWatermark,
+		parseText,
+		parseRadius,
+		parseOps,
+	}
+	for _, parse := range parsers {
+		if err := parse(query, options); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// returns the cache key segment for the options outside of the
+// mode/width/height/ratio/quality layout. It is empty when they are all at
+// their defaults so existing cache keys stay valid.
+func (options *Options) Variant() string {
+	var parts []string
+	if options.Enlarge {
+		parts = append(parts, "enlarge")
+	}
+	// Lanczos was the only filter before it became configurable
+	if options.Filter != "" && options.Filter != Lanczos {
+		parts = append(parts, "filter-"+options.Filter)
+	}
+	variants := []func(*Options) []string{
+		regionVariant,
+		trimVariant,
+		orientationVariant,
+		backgroundVariant,
+		adjustmentsVariant,
+		effectsVariant,
+		watermarkVariant,
+		textVariant,
+		radiusVariant,
+		opsVariant,
+	}
+	for _, variant := range variants {
+		parts = append(parts, variant(options)...)
+	}
+	// The variant is one path segment of local cache keys, so long ones are
+	// hashed to stay within file name length limits
+	variant := strings.Join(parts, "_")
+	if len(variant) > maxVariantLength {
+		sum := sha256.Sum256([]byte(variant))
+		return "hash-" + hex.EncodeToString(sum[:16])
+	}
+	return variant
+}
+
+// response headers describing how the request headers affected the output:
+// Accept-CH asks browsers for the client hints read by ParseQuery, and Vary
+// lists the headers the output depends on
+func ResponseHeaders(options *Options) map[string]string {
+	headers := map[string]string{}
+	var vary []string
+	if settings.ClientHints && !settings.PresetsOnly {
+		headers["Accept-CH"] = "Sec-CH-DPR, DPR, Sec-CH-Width, Width"
+		vary = append(vary, "Sec-CH-DPR", "DPR", "Sec-CH-Width", "Width")
+	}
+	if options.AutoFormat {
+		vary = append(vary, "Accept")
+	}
+	if len(vary) > 0 {
+		headers["Vary"] = strings.Join(vary, ", ")
+	}
+	return headers
+}
+
+// picks the best format the client accepts, falling back to the source format
+func negotiateFormat(header func(string) string, sourceFormat string) string {
+	accept := ""
+	if header != nil {
+		accept = header("Accept")
+	}
+	switch {
+	case strings.Contains(accept, "image/avif"):
+		return "avif"
+	case strings.Contains(accept, "image/webp"):
+		return "webp"
+	default:
+		return sourceFormat
+	}
+}
+
+func parseDPR(dprQuery string) (float32, error) {
+	dpr, err := strconv.ParseFloat(dprQuery, 32)
+	if err != nil || dpr <= 0 || math.IsNaN(dpr) || math.IsInf(dpr, 0) {
+		return 0, fmt.Errorf("invalid dpr: %s", dprQuery)
+	}
+	return min(float32(dpr), settings.MaxDPR), nil
+}
+
+func clientHint(header func(string) string, names ...string) string {
+	if header == nil {
+		return ""
+	}
+	for _, name := range names {
+		if value := header(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// fills in the side missing from width x height from the aspect ratio, a
+// zero ratio or size leaves them unchanged
+func ratioSize(width int, height int, aspectRatio float32) (int, int) {
+	if aspectRatio == 0 {
+		return width, height
+	}
+	if width == 0 && height != 0 {
+		width = int(float32(height) * aspectRatio)
+	} else if height == 0 && width != 0 {
+		height = int(float32(width) / aspectRatio)
+	}
+	return width, height
+}
+
+func scaleDimension(dimension int, dpr float32) int {
+	return int(math.Round(float64(dimension) * float64(dpr)))
+}
+
 func TransformImage(img image.Image, options *Options) (*bytes.Buffer, error) {
 	// Apply transformations
+	img, err := extractRegion(img, options)
+	if err != nil {
+		return nil, err
+	}
+	img = trim(img, options)
+	if options.Background == DominantBackground {
+		options.dominant = dominantColor(img)
+	}
+	img = orient(img, options)
+	sourceWidth := img.Bounds().Dx()
+
 	if options.AspectRatio != 0 {
 		if options.Width == 0 && options.Height == 0 {
 			options.Width = img.Bounds().Dx()
-			options.Height = int(float32(options.Width) / options.AspectRatio)
-		} else if options.Width == 0 {
-			options.Width = int(float32(options.Height) * options.AspectRatio)
-		} else if options.Height == 0 {
-			options.Height = int(float32(options.Width) / options.AspectRatio)
 		}
+		options.Width, options.Height = ratioSize(options.Width, options.Height, options.AspectRatio)
 	}
 
 	if options.Width > 0 || options.Height > 0 {
+		// Filling and padding need both sides, with only one they are the same as fitting
+		mode := options.Mode
+		if (mode == Fill || mode == Pad) && (options.Width == 0 || options.Height == 0) {
+			mode = Fit
+		}
+
+		// imaging.Fit never upscales so the image is enlarged to the bounding box first
+		filter := resampleFilter(options.Filter)
+		if (mode == Fit || mode == Pad) && options.Enlarge {
+			img = enlargeToFit(img, options.Width, options.Height, filter)
+		}
+
 		if options.Height == 0 {
 			options.Height = img.Bounds().Dy()
 		}
@@ -150,13 +466,39 @@ func TransformImage(img image.Image, options *Options) (*bytes.Buffer, error) {
 			options.Width = img.Bounds().Dx()
 		}
 
-		if options.Mode == Crop {
+		if mode == Crop {
+			if options.Enlarge {
+				img = enlargeToCover(img, options.Width, options.Height, filter)
+			}
+			img = imaging.CropCenter(img, options.Width, options.Height)
+		} else if mode == Fill {
+			img = cover(img, options.Width, options.Height, filter, options.Enlarge)
 			img = imaging.CropCenter(img, options.Width, options.Height)
 		} else {
-			img = imaging.Fit(img, options.Width, options.Height, imaging.Lanczos)
+			img = prescale(img, options.Width, options.Height)
+			img = imaging.Fit(img, options.Width, options.Height, filter)
+			if mode == Pad {
+				img = pad(img, options)
+			}
 		}
 	}
 
+	img = adjust(img, options)
+	img = applyEffects(img, options, float64(sourceWidth)/float64(img.Bounds().Dx()))
+	img, err = applyOps(img, options)
+	if err != nil {
+		return nil, err
+	}
+	img, err = applyWatermark(img, options)
+	if err != nil {
+		return nil, err
+	}
+	img, err = applyText(img, options)
+	if err != nil {
+		return nil, err
+	}
+	img = roundCorners(img, options)
+
 	// Set output quality
 	qualityPercent := 100
 	if options.Quality > 0 {
@@ -165,7 +507,6 @@ func TransformImage(img image.Image, options *Options) (*bytes.Buffer, error) {
 
 	// Convert and encode the image
 	var buf bytes.Buffer
-	var err error
 	switch strings.ToLower(options.Format) {
 	case "jpeg", "jpg":
 		err = imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(qualityPercent))
@@ -175,9 +516,81 @@ func TransformImage(img image.Image, options *Options) (*bytes.Buffer, error) {
 		err = webp.Encode(&buf, img, &webp.Options{Lossless: true, Quality: float32(qualityPercent), Exact: true})
 	case "avif":
 		err = avif.Encode(&buf, img, avif.Options{Quality: qualityPercent})
+	case BlurHash, ThumbHash:
+		buf.WriteString(encodePlaceholder(img, options.Format))
 	default:
 		err = imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(qualityPercent))
 	}
 
 	return &buf, err
 }
+
+// centers the image on a canvas of the requested size filled with the background,
+// white when there is none and the output has no alpha
+func pad(img image.Image, options *Options) image.Image {
+	canvas := imaging.New(options.Width, options.Height, options.fillColor())
+	return imaging.OverlayCenter(canvas, img, 1)
+}
+
+// scales the image up to fit within width x height, a zero dimension is unbounded
+func enlargeToFit(img image.Image, width int, height int, filter imaging.ResampleFilter) image.Image {
+	scale := math.Inf(1)
+	if width > 0 {
+		scale = float64(width) / float64(img.Bounds().Dx())
+	}
+	if height > 0 {
+		scale = min(scale, float64(height)/float64(img.Bounds().Dy()))
+	}
+	return enlarge(img, scale, filter)
+}
+
+// scales the image so it just covers width x height, only scaling up when enlarge is set
+func cover(img image.Image, width int, height int, filter imaging.ResampleFilter, enlarge bool) image.Image {
+	scale := max(float64(width)/float64(img.Bounds().Dx()), float64(height)/float64(img.Bounds().Dy()))
+	if scale > 1 {
+		if !enlarge {
+			return img
+		}
+		return scaleImage(img, scale, filter)
+	}
+	if scale == 1 {
+		return img
+	}
+	coverWidth := int(math.Ceil(float64(img.Bounds().Dx()) * scale))
+	coverHeight := int(math.Ceil(float64(img.Bounds().Dy()) * scale))
+	img = prescale(img, coverWidth, coverHeight)
+	return imaging.Resize(img, coverWidth, coverHeight, filter)
+}
+
+// scales the image up until it covers width x height
+func enlargeToCover(img image.Image, width int, height int, filter imaging.ResampleFilter) image.Image {
+	scale := max(float64(width)/float64(img.Bounds().Dx()), float64(height)/float64(img.Bounds().Dy()))
+	return enlarge(img, scale, filter)
+}
+
+func enlarge(img image.Image, scale float64, filter imaging.ResampleFilter) image.Image {
+	if scale <= 1 {
+		return img
+	}
+	return scaleImage(img, scale, filter)
+}
+
+func scaleImage(img image.Image, scale float64, filter imaging.ResampleFilter) image.Image {
+	width := int(math.Round(float64(img.Bounds().Dx()) * scale))
+	height := int(math.Round(float64(img.Bounds().Dy()) * scale))
+	return imaging.Resize(img, width, height, filter)
+}
+
+// Large downscales are mostly spent in the filter, so the image is first
+// shrunk with a box filter to twice the target size and the requested filter
+// only runs on the last step
+func prescale(img image.Image, width int, height int) image.Image {
+	if settings.FastDownscaleRatio <= 0 {
+		return img
+	}
+	scale := min(float64(width)/float64(img.Bounds().Dx()), float64(height)/float64(img.Bounds().Dy()))
+	if scale <= 0 || 1/scale <= settings.FastDownscaleRatio {
+		return img
+	}
+	return scaleImage(img, scale*2, imaging.Box)
+}
@@ -3,12 +3,19 @@ package transformations
 import (
 	"bytes"
 	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/chai2010/webp"
 	"github.com/disintegration/imaging"
 	"github.com/gen2brain/avif"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
 )
 
 func TestTransformImage(t *testing.T) {
@@ -137,3 +144,1199 @@ func TestTransformImage(t *testing.T) {
 		})
 	}
 }
+
+func TestParseQuery(t *testing.T) {
+	type args struct {
+		query   map[string]string
+		header  map[string]string
+		hints   bool
+		options *Options
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *Options
+		wantErr bool
+	}{
+		{
+			name: "DPR scales width and height",
+			args: args{
+				query:   map[string]string{"width": "100", "height": "50", "dpr": "1.5"},
+				options: &Options{},
+			},
+			want: &Options{Width: 150, Height: 75, Filter: Lanczos},
+		},
+		{
+			name: "DPR is capped",
+			args: args{
+				query:   map[string]string{"width": "100", "dpr": "5"},
+				options: &Options{},
+			},
+			want: &Options{Width: 300, Filter: Lanczos},
+		},
+		{
+			name: "Invalid DPR",
+			args: args{
+				query:   map[string]string{"width": "100", "dpr": "0"},
+				options: &Options{},
+			},
+			want:    &Options{Width: 100},
+			wantErr: true,
+		},
+		{
+			name: "DPR hint ignored when client hints are disabled",
+			args: args{
+				query:   map[string]string{"width": "100"},
+				header:  map[string]string{"Sec-CH-DPR": "2"},
+				options: &Options{},
+			},
+			want: &Options{Width: 100, Filter: Lanczos},
+		},
+		{
+			name: "DPR hint applied when client hints are enabled",
+			args: args{
+				query:   map[string]string{"width": "100"},
+				header:  map[string]string{"DPR": "2"},
+				hints:   true,
+				options: &Options{},
+			},
+			want: &Options{Width: 200, Filter: Lanczos},
+		},
+		{
+			name: "Query DPR takes precedence over hint",
+			args: args{
+				query:   map[string]string{"width": "100", "dpr": "1"},
+				header:  map[string]string{"Sec-CH-DPR": "2"},
+				hints:   true,
+				options: &Options{},
+			},
+			want: &Options{Width: 100, Filter: Lanczos},
+		},
+		{
+			name: "Requested size exceeds maximum",
+			args: args{
+				query:   map[string]string{"width": "5000"},
+				options: &Options{},
+			},
+			want:    &Options{Width: 5000},
+			wantErr: true,
+		},
+		{
+			name: "Negative width",
+			args: args{
+				query:   map[string]string{"width": "-50", "height": "20", "mode": "pad"},
+				options: &Options{},
+			},
+			want:    &Options{Width: -50, Height: 20, Mode: Pad},
+			wantErr: true,
+		},
+		{
+			name: "Negative height",
+			args: args{
+				query:   map[string]string{"height": "-1"},
+				options: &Options{},
+			},
+			want:    &Options{Height: -1},
+			wantErr: true,
+		},
+		{
+			name: "Size implied by the ratio exceeds maximum",
+			args: args{
+				query:   map[string]string{"height": "4000", "ratio": "16x9", "enlarge": "true"},
+				options: &Options{},
+			},
+			want:    &Options{Height: 4000, AspectRatio: 16.0 / 9.0},
+			wantErr: true,
+		},
+		{
+			name: "Size implied by the ratio within maximum",
+			args: args{
+				query:   map[string]string{"height": "2000", "ratio": "16x9"},
+				options: &Options{},
+			},
+			want: &Options{Height: 2000, AspectRatio: 16.0 / 9.0, Filter: Lanczos},
+		},
+		{
+			name: "DPR pushes size over maximum",
+			args: args{
+				query:   map[string]string{"height": "2000", "dpr": "3"},
+				options: &Options{},
+			},
+			want:    &Options{Height: 6000},
+			wantErr: true,
+		},
+		{
+			name: "Filter",
+			args: args{
+				query:   map[string]string{"width": "100", "filter": "box"},
+				options: &Options{},
+			},
+			want: &Options{Width: 100, Filter: "box"},
+		},
+		{
+			name: "Invalid filter",
+			args: args{
+				query:   map[string]string{"filter": "bicubic"},
+				options: &Options{},
+			},
+			want:    &Options{Filter: Lanczos},
+			wantErr: true,
+		},
+		{
+			name: "Enlarge",
+			args: args{
+				query:   map[string]string{"width": "100", "enlarge": "true"},
+				options: &Options{},
+			},
+			want: &Options{Width: 100, Enlarge: true, Filter: Lanczos},
+		},
+		{
+			name: "Invalid enlarge",
+			args: args{
+				query:   map[string]string{"enlarge": "sometimes"},
+				options: &Options{},
+			},
+			want:    &Options{Filter: Lanczos},
+			wantErr: true,
+		},
+		{
+			name: "Rotation is normalised",
+			args: args{
+				query:   map[string]string{"rotate": "-90", "flip": "vh", "bg": "#fff"},
+				options: &Options{},
+			},
+			want: &Options{Filter: Lanczos, Rotate: 270, Flip: FlipBoth, Background: "ffffffff"},
+		},
+		{
+			name: "Invalid flip",
+			args: args{
+				query:   map[string]string{"flip": "x"},
+				options: &Options{},
+			},
+			want:    &Options{Filter: Lanczos},
+			wantErr: true,
+		},
+		{
+			name: "Invalid background",
+			args: args{
+				query:   map[string]string{"bg": "red"},
+				options: &Options{},
+			},
+			want:    &Options{Filter: Lanczos},
+			wantErr: true,
+		},
+		{
+			name: "Color adjustments",
+			args: args{
+				query:   map[string]string{"brightness": "-20", "contrast": "15.5", "saturation": "-100", "gamma": "1", "grayscale": "true"},
+				options: &Options{},
+			},
+			want: &Options{Filter: Lanczos, Brightness: -20, Contrast: 15.5, Saturation: -100, Grayscale: true},
+		},
+		{
+			name: "Adjustment out of range",
+			args: args{
+				query:   map[string]string{"gamma": "0"},
+				options: &Options{},
+			},
+			want:    &Options{Filter: Lanczos},
+			wantErr: true,
+		},
+		{
+			name: "Effects",
+			args: args{
+				query:   map[string]string{"blur": "2.5", "sharpen": "1", "sharpen_amount": "2", "pixelate": "8"},
+				options: &Options{},
+			},
+			want: &Options{Filter: Lanczos, Blur: 2.5, Sharpen: 1, SharpenAmount: 2, Pixelate: 8},
+		},
+		{
+			name: "Blur sigma is capped",
+			args: args{
+				query:   map[string]string{"blur": "500"},
+				options: &Options{},
+			},
+			want:    &Options{Filter: Lanczos},
+			wantErr: true,
+		},
+		{
+			name: "Rounded JPEG becomes PNG",
+			args: args{
+				query:   map[string]string{"radius": "max"},
+				options: &Options{Format: "jpg"},
+			},
+			want: &Options{Format: "png", Filter: Lanczos, Radius: MaxRadius},
+		},
+		{
+			name: "Rounded JPEG with background",
+			args: args{
+				query:   map[string]string{"radius": "12", "bg": "fff"},
+				options: &Options{Format: "jpeg"},
+			},
+			want: &Options{Format: "jpeg", Filter: Lanczos, Background: "ffffffff", Radius: 12},
+		},
+		{
+			name: "Percentage rect",
+			args: args{
+				query:   map[string]string{"rect": "pct:25,0,50,100"},
+				options: &Options{},
+			},
+			want: &Options{Filter: Lanczos, Region: &Region{X: 0.25, Width: 0.5, Height: 1, Fractional: true}},
+		},
+		{
+			name: "Pixel rect",
+			args: args{
+				query:   map[string]string{"rect": "10,20,300,200"},
+				options: &Options{},
+			},
+			want: &Options{Filter: Lanczos, Region: &Region{X: 10, Y: 20, Width: 300, Height: 200}},
+		},
+		{
+			name: "One pixel rect",
+			args: args{
+				query:   map[string]string{"rect": "0,0,1,1"},
+				options: &Options{},
+			},
+			want: &Options{Filter: Lanczos, Region: &Region{Width: 1, Height: 1}},
+		},
+		{
+			name: "Fractional pixel rect",
+			args: args{
+				query:   map[string]string{"rect": "0.25,0,0.5,1"},
+				options: &Options{},
+			},
+			want:    &Options{Filter: Lanczos},
+			wantErr: true,
+		},
+		{
+			name: "Percentage rect over 100",
+			args: args{
+				query:   map[string]string{"rect": "pct:0,0,150,100"},
+				options: &Options{},
+			},
+			want:    &Options{Filter: Lanczos},
+			wantErr: true,
+		},
+		{
+			name: "Invalid rect",
+			args: args{
+				query:   map[string]string{"rect": "10,20,300"},
+				options: &Options{},
+			},
+			want:    &Options{Filter: Lanczos},
+			wantErr: true,
+		},
+		{
+			name: "Trim with default tolerance",
+			args: args{
+				query:   map[string]string{"trim": "true", "trim_color": "fff"},
+				options: &Options{},
+			},
+			want: &Options{Filter: Lanczos, Trim: &TrimOptions{Tolerance: 10, Color: "ffffffff"}},
+		},
+		{
+			name: "Trim enabled by 1",
+			args: args{
+				query:   map[string]string{"trim": "1"},
+				options: &Options{},
+			},
+			want: &Options{Filter: Lanczos, Trim: &TrimOptions{Tolerance: 10}},
+		},
+		{
+			name: "Trim disabled by 0",
+			args: args{
+				query:   map[string]string{"trim": "0"},
+				options: &Options{},
+			},
+			want: &Options{Filter: Lanczos},
+		},
+		{
+			name: "Trim tolerance",
+			args: args{
+				query:   map[string]string{"trim": "2"},
+				options: &Options{},
+			},
+			want: &Options{Filter: Lanczos, Trim: &TrimOptions{Tolerance: 2}},
+		},
+		{
+			name: "Negative trim tolerance",
+			args: args{
+				query:   map[string]string{"trim": "-2"},
+				options: &Options{},
+			},
+			want:    &Options{Filter: Lanczos},
+			wantErr: true,
+		},
+		{
+			name: "Trim tolerance out of range",
+			args: args{
+				query:   map[string]string{"trim": "300"},
+				options: &Options{},
+			},
+			want:    &Options{Filter: Lanczos},
+			wantErr: true,
+		},
+		{
+			name: "Width hint is clamped to maximum",
+			args: args{
+				query:   map[string]string{},
+				header:  map[string]string{"Width": "9000"},
+				hints:   true,
+				options: &Options{},
+			},
+			want: &Options{Width: 4096, Filter: Lanczos},
+		},
+		{
+			name: "Width hint used without explicit dimensions",
+			args: args{
+				query:   map[string]string{},
+				header:  map[string]string{"Sec-CH-DPR": "2", "Sec-CH-Width": "640"},
+				hints:   true,
+				options: &Options{},
+			},
+			want: &Options{Width: 640, Filter: Lanczos},
+		},
+		{
+			name: "Auto format prefers avif",
+			args: args{
+				query:   map[string]string{"format": "auto"},
+				header:  map[string]string{"Accept": "image/avif,image/webp,*/*"},
+				options: &Options{Format: "jpg"},
+			},
+			want: &Options{Format: "avif", AutoFormat: true, Filter: Lanczos},
+		},
+		{
+			name: "Auto format falls back to the source format",
+			args: args{
+				query:   map[string]string{"format": "auto"},
+				header:  map[string]string{"Accept": "*/*"},
+				options: &Options{Format: "png"},
+			},
+			want: &Options{Format: "png", AutoFormat: true, Filter: Lanczos},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings = DefaultSettings()
+			settings.ClientHints = tt.args.hints
+			defer func() { settings = DefaultSettings() }()
+
+			query := func(name string) string { return tt.args.query[name] }
+			header := func(name string) string { return tt.args.header[name] }
+			err := ParseQuery(query, header, tt.args.options)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseQuery() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(tt.args.options, tt.want) {
+				t.Errorf("ParseQuery() = %+v, want %+v", tt.args.options, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformImageSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *Options
+		want    image.Point
+	}{
+		{
+			name:    "Fit does not upscale",
+			options: &Options{Width: 200, Height: 200, Mode: Fit, Format: "png"},
+			want:    image.Pt(100, 50),
+		},
+		{
+			name:    "Fit with enlarge",
+			options: &Options{Width: 200, Height: 200, Mode: Fit, Format: "png", Enlarge: true},
+			want:    image.Pt(200, 100),
+		},
+		{
+			name:    "Fit width only with enlarge",
+			options: &Options{Width: 300, Mode: Fit, Format: "png", Enlarge: true},
+			want:    image.Pt(300, 150),
+		},
+		{
+			name:    "Fit with fast downscale",
+			options: &Options{Width: 10, Height: 10, Mode: Fit, Format: "png", Filter: "catmull-rom"},
+			want:    image.Pt(10, 5),
+		},
+		{
+			name:    "Rotate before resize",
+			options: &Options{Width: 40, Mode: Fit, Format: "png", Rotate: 90},
+			want:    image.Pt(40, 80),
+		},
+		{
+			name:    "Arbitrary rotation grows the canvas",
+			options: &Options{Mode: Fit, Format: "png", Rotate: 45},
+			want:    image.Pt(106, 106),
+		},
+		{
+			name:    "Region before fit",
+			options: &Options{Width: 20, Mode: Fit, Format: "png", Region: &Region{X: 0, Y: 0, Width: 0.5, Height: 1, Fractional: true}},
+			want:    image.Pt(20, 20),
+		},
+		{
+			name:    "Region is clamped to the source",
+			options: &Options{Mode: Fit, Format: "png", Region: &Region{X: 80, Y: 40, Width: 100, Height: 100}},
+			want:    image.Pt(20, 10),
+		},
+		{
+			name:    "Crop does not upscale",
+			options: &Options{Width: 80, Height: 80, Mode: Crop, Format: "png"},
+			want:    image.Pt(80, 50),
+		},
+		{
+			name:    "Crop with enlarge",
+			options: &Options{Width: 80, Height: 80, Mode: Crop, Format: "png", Enlarge: true},
+			want:    image.Pt(80, 80),
+		},
+		{
+			name:    "Fill scales down to cover",
+			options: &Options{Width: 40, Height: 40, Mode: Fill, Format: "png"},
+			want:    image.Pt(40, 40),
+		},
+		{
+			name:    "Fill does not upscale",
+			options: &Options{Width: 80, Height: 80, Mode: Fill, Format: "png"},
+			want:    image.Pt(80, 50),
+		},
+		{
+			name:    "Pad to the requested size",
+			options: &Options{Width: 80, Height: 80, Mode: Pad, Format: "png"},
+			want:    image.Pt(80, 80),
+		},
+		{
+			name:    "Fill with one side fits",
+			options: &Options{Width: 40, Mode: Fill, Format: "png"},
+			want:    image.Pt(40, 20),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := transformedSize(t, image.NewRGBA(image.Rect(0, 0, 100, 50)), tt.options)
+			if got != tt.want {
+				t.Errorf("TransformImage() size = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func transformedSize(t *testing.T, img image.Image, options *Options) image.Point {
+	t.Helper()
+	buf, err := TransformImage(img, options)
+	if err != nil {
+		t.Fatalf("TransformImage() error = %v", err)
+	}
+	config, err := png.DecodeConfig(buf)
+	if err != nil {
+		t.Fatalf("could not decode transformed image: %v", err)
+	}
+	return image.Pt(config.Width, config.Height)
+}
+
+func TestOrient(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.NRGBA{R: 255, A: 255})
+
+	tests := []struct {
+		name    string
+		options *Options
+		want    image.Point
+	}{
+		{name: "Clockwise rotation", options: &Options{Rotate: 90}, want: image.Pt(0, 0)},
+		{name: "Counter-clockwise rotation", options: &Options{Rotate: 270}, want: image.Pt(0, 1)},
+		{name: "Half turn", options: &Options{Rotate: 180}, want: image.Pt(1, 0)},
+		{name: "Horizontal flip", options: &Options{Flip: FlipHorizontal}, want: image.Pt(1, 0)},
+		{name: "Vertical flip", options: &Options{Flip: FlipVertical}, want: image.Pt(0, 0)},
+		{name: "Rotate then flip", options: &Options{Rotate: 90, Flip: FlipBoth}, want: image.Pt(0, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := orient(src, tt.options)
+			if r, _, _, _ := got.At(tt.want.X, tt.want.Y).RGBA(); r == 0 {
+				t.Errorf("orient() red pixel not at %v", tt.want)
+			}
+		})
+	}
+}
+
+func TestOrientBackground(t *testing.T) {
+	src := imaging.New(10, 10, color.NRGBA{R: 255, A: 255})
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+
+	tests := []struct {
+		name    string
+		options *Options
+		ops     string
+		want    color.NRGBA
+	}{
+		{name: "JPEG without background", options: &Options{Rotate: 45, Format: "jpg"}, want: white},
+		{name: "PNG without background", options: &Options{Rotate: 45, Format: "png"}, want: color.NRGBA{}},
+		{name: "JPEG with background", options: &Options{Rotate: 45, Format: "jpg", Background: "000000ff"}, want: color.NRGBA{A: 255}},
+		{name: "JPEG rotated by ops", options: &Options{Format: "jpg"}, ops: "rotate:45", want: white},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got image.Image
+			if tt.ops != "" {
+				if err := parseOps(func(name string) string { return map[string]string{"ops": tt.ops}[name] }, tt.options); err != nil {
+					t.Fatal(err)
+				}
+				var err error
+				if got, err = applyOps(src, tt.options); err != nil {
+					t.Fatalf("applyOps() error = %v", err)
+				}
+			} else {
+				got = orient(src, tt.options)
+			}
+			if corner := color.NRGBAModel.Convert(got.At(0, 0)).(color.NRGBA); corner != tt.want {
+				t.Errorf("corner = %v, want %v", corner, tt.want)
+			}
+		})
+	}
+}
+
+func TestVariant(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *Options
+		want    string
+	}{
+		{name: "Defaults", options: &Options{Filter: Lanczos}, want: ""},
+		{name: "Enlarge and filter", options: &Options{Enlarge: true, Filter: "box"}, want: "enlarge_filter-box"},
+		{name: "Orientation", options: &Options{Rotate: 22.5, Flip: FlipHorizontal, Background: "000000ff"}, want: "rotate-22.5_flip-h_bg-000000ff"},
+		{name: "Adjustments", options: &Options{Brightness: 10, Gamma: 2.2, Grayscale: true}, want: "brightness-10_gamma-2.2_grayscale"},
+		{name: "Effects", options: &Options{Blur: 3, Sharpen: 0.5, SharpenAmount: 2, Pixelate: 4}, want: "blur-3_sharpen-0.5_amount-2_pixelate-4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.options.Variant(); got != tt.want {
+				t.Errorf("Variant() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	long := &Options{}
+	ops := strings.Repeat("rect:pct:12.3456789,12.3456789,50,50|", 9) + "rect:pct:12.3456789,12.3456789,50,50"
+	if err := parseOps(func(name string) string { return map[string]string{"ops": ops}[name] }, long); err != nil {
+		t.Fatalf("parseOps() error = %v", err)
+	}
+	got := long.Variant()
+	if len(got) > maxVariantLength || !strings.HasPrefix(got, "hash-") {
+		t.Errorf("Variant() = %q, want a hash of at most %d bytes", got, maxVariantLength)
+	}
+	other := &Options{Ops: long.Ops[:9]}
+	if other.Variant() == got {
+		t.Errorf("Variant() hashes of different options are equal")
+	}
+}
+
+func TestPixelate(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 10, 5))
+	for x := 0; x < 10; x++ {
+		src.Set(x, 0, color.NRGBA{R: uint8(x * 25), A: 255})
+	}
+
+	got := imaging.Clone(pixelate(src, 4))
+	if got.Bounds() != src.Bounds() {
+		t.Fatalf("pixelate() bounds = %v, want %v", got.Bounds(), src.Bounds())
+	}
+	if got.NRGBAAt(0, 0) != got.NRGBAAt(3, 3) {
+		t.Errorf("pixelate() block is not uniform: %v != %v", got.NRGBAAt(0, 0), got.NRGBAAt(3, 3))
+	}
+}
+
+func TestApplyWatermark(t *testing.T) {
+	dir := t.TempDir()
+	logo := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	for i := range logo.Pix {
+		logo.Pix[i] = 255
+	}
+	if err := imaging.Save(logo, filepath.Join(dir, "logo.png")); err != nil {
+		t.Fatal(err)
+	}
+	config := `{"logo": {"source": "` + filepath.Join(dir, "logo.png") + `", "gravity": "southeast", "scale": 0.1}}`
+	if err := os.WriteFile(filepath.Join(dir, "watermarks.json"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadWatermarks(filepath.Join(dir, "watermarks.json")); err != nil {
+		t.Fatalf("LoadWatermarks() error = %v", err)
+	}
+	defer func() { watermarks = map[string]*Watermark{} }()
+
+	tests := []struct {
+		name    string
+		query   map[string]string
+		covered []image.Point
+		bare    []image.Point
+		wantErr bool
+	}{
+		{
+			name:    "Configured gravity",
+			query:   map[string]string{"watermark": "logo"},
+			covered: []image.Point{image.Pt(99, 99), image.Pt(90, 90)},
+			bare:    []image.Point{image.Pt(0, 0), image.Pt(89, 89)},
+		},
+		{
+			name:    "Gravity override",
+			query:   map[string]string{"watermark": "logo", "wm_gravity": "northwest"},
+			covered: []image.Point{image.Pt(0, 0)},
+			bare:    []image.Point{image.Pt(99, 99)},
+		},
+		{
+			name:    "Tiled",
+			query:   map[string]string{"watermark": "logo", "wm_tile": "true"},
+			covered: []image.Point{image.Pt(0, 0), image.Pt(55, 55), image.Pt(99, 99)},
+		},
+		{
+			name:    "Unknown watermark",
+			query:   map[string]string{"watermark": "https://example.com/evil.png"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := &Options{}
+			err := parseWatermark(func(name string) string { return tt.query[name] }, options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWatermark() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			got, err := applyWatermark(image.NewNRGBA(image.Rect(0, 0, 100, 100)), options)
+			if err != nil {
+				t.Fatalf("applyWatermark() error = %v", err)
+			}
+			for _, point := range tt.covered {
+				if _, _, _, a := got.At(point.X, point.Y).RGBA(); a == 0 {
+					t.Errorf("applyWatermark() pixel %v not covered", point)
+				}
+			}
+			for _, point := range tt.bare {
+				if _, _, _, a := got.At(point.X, point.Y).RGBA(); a != 0 {
+					t.Errorf("applyWatermark() pixel %v covered", point)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyText(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   map[string]string
+		painted image.Point
+		bare    image.Point
+		wantErr bool
+	}{
+		{
+			name:    "Band at the bottom",
+			query:   map[string]string{"text": "Hello world", "text_bg": "000000ff"},
+			painted: image.Pt(0, 199),
+			bare:    image.Pt(0, 0),
+		},
+		{
+			name:    "Band at the top",
+			query:   map[string]string{"text": "Hello world", "text_bg": "000000ff", "text_gravity": "north"},
+			painted: image.Pt(0, 0),
+			bare:    image.Pt(0, 199),
+		},
+		{
+			name:    "Unknown font",
+			query:   map[string]string{"text": "Hello", "text_font": "comic"},
+			wantErr: true,
+		},
+		{
+			name:    "Text too long",
+			query:   map[string]string{"text": strings.Repeat("a", 201)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := &Options{}
+			err := parseText(func(name string) string { return tt.query[name] }, options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			got, err := applyText(image.NewNRGBA(image.Rect(0, 0, 200, 200)), options)
+			if err != nil {
+				t.Fatalf("applyText() error = %v", err)
+			}
+			if _, _, _, a := got.At(tt.painted.X, tt.painted.Y).RGBA(); a == 0 {
+				t.Errorf("applyText() pixel %v not painted", tt.painted)
+			}
+			if _, _, _, a := got.At(tt.bare.X, tt.bare.Y).RGBA(); a != 0 {
+				t.Errorf("applyText() pixel %v painted", tt.bare)
+			}
+		})
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	parsed, err := loadFont("mono")
+	if err != nil {
+		t.Fatal(err)
+	}
+	face, err := opentype.NewFace(parsed, &opentype.FaceOptions{Size: 10, DPI: 72})
+	if err != nil {
+		t.Fatal(err)
+	}
+	charWidth := font.MeasureString(face, "a")
+
+	got := wrapText(face, "aaa bbb ccc\ndd", charWidth*7)
+	want := []string{"aaa bbb", "ccc", "dd"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrapText() = %q, want %q", got, want)
+	}
+}
+
+func TestRoundCorners(t *testing.T) {
+	src := imaging.New(100, 50, color.NRGBA{R: 255, A: 255})
+
+	tests := []struct {
+		name      string
+		options   *Options
+		point     image.Point
+		wantAlpha uint8
+		wantRed   uint8
+	}{
+		{name: "Corner is transparent", options: &Options{Radius: 10, Format: "png"}, point: image.Pt(0, 0), wantAlpha: 0},
+		{name: "Edge is kept", options: &Options{Radius: 10, Format: "png"}, point: image.Pt(50, 0), wantAlpha: 255, wantRed: 255},
+		{name: "Inside the arc is kept", options: &Options{Radius: 10, Format: "png"}, point: image.Pt(5, 5), wantAlpha: 255, wantRed: 255},
+		{name: "Max radius makes a pill", options: &Options{Radius: MaxRadius, Format: "png"}, point: image.Pt(5, 5), wantAlpha: 0},
+		{name: "JPEG corners use the background", options: &Options{Radius: 10, Format: "jpg", Background: "000000ff"}, point: image.Pt(0, 0), wantAlpha: 255, wantRed: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := imaging.Clone(roundCorners(src, tt.options)).NRGBAAt(tt.point.X, tt.point.Y)
+			if got.A != tt.wantAlpha || (tt.wantAlpha != 0 && got.R != tt.wantRed) {
+				t.Errorf("roundCorners() pixel %v = %v", tt.point, got)
+			}
+		})
+	}
+}
+
+func TestTrim(t *testing.T) {
+	src := imaging.New(100, 80, color.NRGBA{R: 250, G: 250, B: 250, A: 255})
+	for y := 10; y < 50; y++ {
+		for x := 20; x < 70; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: 200, A: 255})
+		}
+	}
+	// Noise within the tolerance is still border
+	src.SetNRGBA(5, 5, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+
+	tests := []struct {
+		name    string
+		options *Options
+		want    image.Rectangle
+	}{
+		{name: "Top-left color", options: &Options{Trim: &TrimOptions{Tolerance: 10}}, want: image.Rect(0, 0, 50, 40)},
+		{name: "Zero tolerance keeps noise", options: &Options{Trim: &TrimOptions{}}, want: image.Rect(0, 0, 65, 45)},
+		{name: "Color that does not match", options: &Options{Trim: &TrimOptions{Tolerance: 10, Color: "000000ff"}}, want: image.Rect(0, 0, 100, 80)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := trim(src, tt.options).Bounds(); got != tt.want {
+				t.Errorf("trim() bounds = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOps(t *testing.T) {
+	tests := []struct {
+		name    string
+		ops     string
+		want    string
+		size    image.Point
+		wantErr bool
+	}{
+		{
+			name: "Rotate then crop then blur",
+			ops:  "rotate:90|crop:30x40|blur:2",
+			want: "ops-rotate-90~crop-30x40~blur-2",
+			size: image.Pt(30, 40),
+		},
+		{
+			name: "Order changes the output",
+			ops:  "crop:30x40|rotate:90",
+			want: "ops-crop-30x40~rotate-90",
+			size: image.Pt(40, 30),
+		},
+		{
+			name: "Resize with one side",
+			ops:  "resize:x25|grayscale",
+			want: "ops-resize-0x25~grayscale",
+			size: image.Pt(50, 25),
+		},
+		{
+			name:    "Unknown operation",
+			ops:     "explode:1",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid arguments",
+			ops:     "blur:1000",
+			wantErr: true,
+		},
+		{
+			name:    "Missing arguments",
+			ops:     "rotate",
+			wantErr: true,
+		},
+		{
+			name:    "Too many operations",
+			ops:     strings.Repeat("grayscale|", 10) + "grayscale",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := &Options{}
+			err := parseOps(func(name string) string {
+				if name == "ops" {
+					return tt.ops
+				}
+				return ""
+			}, options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOps() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := options.Variant(); got != tt.want {
+				t.Errorf("Variant() = %q, want %q", got, tt.want)
+			}
+			img, err := applyOps(image.NewNRGBA(image.Rect(0, 0, 100, 50)), options)
+			if err != nil {
+				t.Fatalf("applyOps() error = %v", err)
+			}
+			if got := img.Bounds().Size(); got != tt.size {
+				t.Errorf("applyOps() size = %v, want %v", got, tt.size)
+			}
+		})
+	}
+}
+
+func TestParseQueryPreset(t *testing.T) {
+	dir := t.TempDir()
+	config := `{"card-thumb": {"width": "320", "height": "180", "mode": "crop", "format": "webp", "quality": "75"},
+		"auto-thumb": {"width": "320", "format": "auto"}}`
+	if err := os.WriteFile(filepath.Join(dir, "presets.json"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadPresets(filepath.Join(dir, "presets.json")); err != nil {
+		t.Fatalf("LoadPresets() error = %v", err)
+	}
+	defer func() { presets = map[string]Preset{} }()
+
+	cardThumb := &Options{Width: 320, Height: 180, Mode: Crop, Format: "webp", Quality: 75, Filter: Lanczos}
+	tests := []struct {
+		name        string
+		query       map[string]string
+		header      map[string]string
+		presetsOnly bool
+		want        *Options
+		wantErr     bool
+	}{
+		{
+			name:  "Preset",
+			query: map[string]string{"preset": "card-thumb"},
+			want:  cardThumb,
+		},
+		{
+			name:  "Query overrides preset",
+			query: map[string]string{"preset": "card-thumb", "quality": "50"},
+			want:  &Options{Width: 320, Height: 180, Mode: Crop, Format: "webp", Quality: 50, Filter: Lanczos},
+		},
+		{
+			name:        "Presets only ignores other parameters",
+			query:       map[string]string{"preset": "card-thumb", "quality": "50"},
+			presetsOnly: true,
+			want:        cardThumb,
+		},
+		{
+			name:        "Presets only negotiates format=auto",
+			query:       map[string]string{"preset": "auto-thumb"},
+			header:      map[string]string{"Accept": "image/webp,*/*", "Width": "100", "DPR": "2"},
+			presetsOnly: true,
+			want:        &Options{Width: 320, Mode: Fit, Format: "webp", AutoFormat: true, Quality: 100, Filter: Lanczos},
+		},
+		{
+			name:        "Presets only requires a preset",
+			query:       map[string]string{"width": "100"},
+			presetsOnly: true,
+			wantErr:     true,
+		},
+		{
+			name:    "Unknown preset",
+			query:   map[string]string{"preset": "hero"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings = DefaultSettings()
+			settings.ClientHints = true
+			settings.PresetsOnly = tt.presetsOnly
+			defer func() { settings = DefaultSettings() }()
+
+			options := &Options{Mode: Fit, Quality: 100, Format: "jpg"}
+			err := ParseQuery(func(name string) string { return tt.query[name] },
+				func(name string) string { return tt.header[name] }, options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(options, tt.want) {
+				t.Errorf("ParseQuery() = %+v, want %+v", options, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlaceholder(t *testing.T) {
+	gradient := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 11), uint8(x * y), 255})
+		}
+	}
+
+	tests := []struct {
+		name   string
+		img    image.Image
+		format string
+		want   string
+	}{
+		{
+			name:   "Blurhash of a solid color",
+			img:    imaging.New(64, 48, color.Black),
+			format: BlurHash,
+			want:   "L00000fQfQfQfQfQfQfQfQfQfQfQ",
+		},
+		{
+			name:   "Thumbhash of a gradient",
+			img:    gradient,
+			format: ThumbHash,
+			want:   "WxgKFZQwSFlzh4hwiJiIhZPMALT3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := &Options{Mode: Fit}
+			if err := ParseQuery(func(name string) string { return map[string]string{"format": tt.format}[name] }, nil, options); err != nil {
+				t.Fatal(err)
+			}
+			buf, err := TransformImage(tt.img, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("TransformImage() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPalette(t *testing.T) {
+	img := imaging.New(100, 100, color.NRGBA{R: 255, A: 255})
+	img = imaging.Paste(img, imaging.New(50, 50, color.NRGBA{B: 255, A: 255}), image.Pt(50, 50))
+
+	got := Palette(img, 2)
+	want := []PaletteColor{{Color: "#ff0000", Share: 0.75}, {Color: "#0000ff", Share: 0.25}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Palette() = %+v, want %+v", got, want)
+	}
+
+	// A single color image has a single color palette however many are asked for
+	if got := Palette(imaging.New(10, 10, color.White), 5); len(got) != 1 || got[0].Share != 1 {
+		t.Errorf("Palette() = %+v, want a single color", got)
+	}
+}
+
+func TestPadDominantBackground(t *testing.T) {
+	img := imaging.New(100, 50, color.NRGBA{R: 200, G: 40, B: 40, A: 255})
+	img = imaging.Paste(img, imaging.New(10, 10, color.Black), image.Pt(0, 0))
+
+	options := &Options{Mode: Fit, Format: "png"}
+	query := map[string]string{"width": "100", "height": "100", "mode": "pad", "bg": "dominant"}
+	if err := ParseQuery(func(name string) string { return query[name] }, nil, options); err != nil {
+		t.Fatal(err)
+	}
+	if got := options.Variant(); got != "bg-dominant" {
+		t.Errorf("Variant() = %s, want bg-dominant", got)
+	}
+
+	buf, err := TransformImage(img, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	padded, err := png.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if padded.Bounds().Dx() != 100 || padded.Bounds().Dy() != 100 {
+		t.Fatalf("size = %v, want 100x100", padded.Bounds().Size())
+	}
+	if got := color.NRGBAModel.Convert(padded.At(50, 5)); got != (color.NRGBA{R: 200, G: 40, B: 40, A: 255}) {
+		t.Errorf("padding = %v, want the dominant color", got)
+	}
+}
+
+func TestLoadSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{name: "Defaults", env: map[string]string{}},
+		{name: "Fast downscale disabled", env: map[string]string{"FAST_DOWNSCALE_RATIO": "0"}},
+		{name: "Fast downscale below 2", env: map[string]string{"FAST_DOWNSCALE_RATIO": "1.5"}, wantErr: true},
+		{name: "Negative fast downscale", env: map[string]string{"FAST_DOWNSCALE_RATIO": "-4"}, wantErr: true},
+		{name: "Auto sharpen ratio", env: map[string]string{"AUTO_SHARPEN_RATIO": "1.5"}},
+		{name: "Auto sharpen ratio below 1", env: map[string]string{"AUTO_SHARPEN_RATIO": "0.5"}, wantErr: true},
+		{name: "Negative auto sharpen ratio", env: map[string]string{"AUTO_SHARPEN_RATIO": "-2"}, wantErr: true},
+		{
+			name:    "Missing presets file",
+			env:     map[string]string{"MAX_OUTPUT_WIDTH": "100", "PRESETS_FILE": filepath.Join(t.TempDir(), "presets.json")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings = DefaultSettings()
+			defer func() { settings = DefaultSettings() }()
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			err := LoadSettings()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadSettings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !reflect.DeepEqual(settings, DefaultSettings()) {
+				t.Errorf("LoadSettings() changed the settings before failing: %+v", settings)
+			}
+		})
+	}
+}
+
+func TestWatermarkConfig(t *testing.T) {
+	dir := t.TempDir()
+	defer func() { watermarks = map[string]*Watermark{} }()
+
+	load := func(config string) error {
+		t.Helper()
+		configPath := filepath.Join(dir, "watermarks.json")
+		if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return LoadWatermarks(configPath)
+	}
+
+	if err := load(`{"logo": {"source": "logo.png", "margin": -1}}`); err == nil {
+		t.Errorf("LoadWatermarks() accepted a negative margin")
+	}
+
+	options := &Options{Watermark: WatermarkOptions{ID: "logo", Gravity: SouthEast, Opacity: 1, Scale: 0.25}}
+	var keys []string
+	for _, config := range []string{
+		`{"logo": {"source": "logo.png", "margin": 8}}`,
+		`{"logo": {"source": "logo.png", "margin": 16}}`,
+		`{"logo": {"source": "logo-v2.png", "margin": 16}}`,
+	} {
+		if err := load(config); err != nil {
+			t.Fatalf("LoadWatermarks() error = %v", err)
+		}
+		key := options.Variant()
+		for _, previous := range keys {
+			if key == previous {
+				t.Errorf("Variant() = %s did not change with the watermark configuration", key)
+			}
+		}
+		keys = append(keys, key)
+	}
+}
+
+func TestApplyOpsSizeCap(t *testing.T) {
+	tests := []struct {
+		name string
+		size image.Point
+		// max is the maximum output size, 1000x1000 when zero
+		max     image.Point
+		ops     string
+		wantErr bool
+	}{
+		{name: "Rotations growing past the maximum", size: image.Pt(200, 200), ops: strings.Repeat("rotate:45|", 9) + "rotate:45", wantErr: true},
+		{name: "Rotations growing past a small maximum", size: image.Pt(200, 100), max: image.Pt(300, 300), ops: "rotate:45|rotate:45|rotate:45|rotate:45", wantErr: true},
+		{name: "Rotation within the maximum", size: image.Pt(200, 200), ops: "rotate:45"},
+		{name: "Rotation past the maximum height", size: image.Pt(800, 200), max: image.Pt(1000, 300), ops: "rotate:90", wantErr: true},
+		{name: "Resize then rotate within the maximum height", size: image.Pt(800, 200), max: image.Pt(1000, 300), ops: "resize:300x300|rotate:90"},
+		{name: "Source larger than the maximum", size: image.Pt(1200, 100), ops: "blur:1|rotate:180|crop:900x100"},
+		{name: "Source larger than the maximum rotated past it", size: image.Pt(1200, 100), ops: "rotate:90", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings = DefaultSettings()
+			settings.MaxWidth, settings.MaxHeight = 1000, 1000
+			if tt.max != (image.Point{}) {
+				settings.MaxWidth, settings.MaxHeight = tt.max.X, tt.max.Y
+			}
+			defer func() { settings = DefaultSettings() }()
+
+			options := &Options{}
+			if err := parseOps(func(name string) string { return map[string]string{"ops": tt.ops}[name] }, options); err != nil {
+				t.Fatalf("parseOps() error = %v", err)
+			}
+			_, err := applyOps(image.NewNRGBA(image.Rectangle{Max: tt.size}), options)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("applyOps() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOrientedSize(t *testing.T) {
+	for _, size := range []image.Point{image.Pt(200, 100), image.Pt(37, 91), image.Pt(1, 1)} {
+		for _, angle := range []float64{0, 1, 22.5, 45, 90, 135, 180, 200, 270, 333.3} {
+			options := &Options{Rotate: angle}
+			got := orientedSize(size, options)
+			want := orient(image.NewNRGBA(image.Rectangle{Max: size}), options).Bounds().Size()
+			if got != want {
+				t.Errorf("orientedSize(%v, %v) = %v, want %v", size, angle, got, want)
+			}
+		}
+	}
+}
+
+// reports a size past any maximum and fails the test when it runs
+type oversizedOperation struct {
+	t *testing.T
+}
+
+func (operation oversizedOperation) Apply(img image.Image) (image.Image, error) {
+	operation.t.Error("Apply() ran although its output exceeds the maximum")
+	return img, nil
+}
+
+func (operation oversizedOperation) Key() string { return "oversized" }
+
+func (operation oversizedOperation) Size(input image.Point) image.Point {
+	return image.Pt(settings.MaxWidth+1, input.Y)
+}
+
+func TestApplyOpsChecksSizeFirst(t *testing.T) {
+	options := &Options{Ops: []Operation{oversizedOperation{t: t}}}
+	if _, err := applyOps(image.NewNRGBA(image.Rect(0, 0, 10, 10)), options); err == nil {
+		t.Errorf("applyOps() accepted an operation exceeding the maximum")
+	}
+}
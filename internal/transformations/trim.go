@@ -0,0 +1,135 @@
+package transformations
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+const defaultTrimTolerance = 10
+
+// TrimOptions removes uniform borders matching Color within Tolerance
+type TrimOptions struct {
+	// Tolerance is the largest per-channel difference still counted as border
+	Tolerance int
+	// Color is the border color, empty to use the top-left pixel
+	Color string
+}
+
+// parses the trim and trim_color query parameters. trim is either a boolean,
+// including 0 and 1, or the tolerance between 2 and 255
+func parseTrim(query func(string) string, options *Options) error {
+	trimQuery := query("trim")
+	if trimQuery == "" {
+		return nil
+	}
+
+	tolerance := defaultTrimTolerance
+	if enabled, err := strconv.ParseBool(trimQuery); err == nil {
+		if !enabled {
+			return nil
+		}
+	} else {
+		tolerance, err = strconv.Atoi(trimQuery)
+		if err != nil {
+			return fmt.Errorf("invalid trim: %s", trimQuery)
+		}
+		if tolerance < 2 || tolerance > 255 {
+			return fmt.Errorf("invalid trim: %s. must be a boolean or between 2 and 255", trimQuery)
+		}
+	}
+	options.Trim = &TrimOptions{Tolerance: tolerance}
+
+	if colorQuery := query("trim_color"); colorQuery != "" {
+		trimColor, err := ParseColor(colorQuery)
+		if err != nil {
+			return fmt.Errorf("invalid trim_color: %s", colorQuery)
+		}
+		options.Trim.Color = FormatColor(trimColor)
+	}
+
+	return nil
+}
+
+func trimVariant(options *Options) []string {
+	if options.Trim == nil {
+		return nil
+	}
+	part := "trim-" + strconv.Itoa(options.Trim.Tolerance)
+	if options.Trim.Color != "" {
+		part += "-" + options.Trim.Color
+	}
+	return []string{part}
+}
+
+// crops off the borders of the image that match the trim color
+func trim(img image.Image, options *Options) image.Image {
+	if options.Trim == nil {
+		return img
+	}
+
+	src := imaging.Clone(img)
+	bounds := src.Bounds()
+	if bounds.Empty() {
+		return img
+	}
+	border := src.NRGBAAt(0, 0)
+	if options.Trim.Color != "" {
+		border, _ = ParseColor(options.Trim.Color)
+	}
+
+	isBorder := func(x, y int) bool {
+		return colorDistance(src.NRGBAAt(x, y), border) <= options.Trim.Tolerance
+	}
+	rowIsBorder := func(y int) bool {
+		for x := 0; x < bounds.Dx(); x++ {
+			if !isBorder(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+	columnIsBorder := func(x, top, bottom int) bool {
+		for y := top; y < bottom; y++ {
+			if !isBorder(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+
+	top, bottom := 0, bounds.Dy()
+	for top < bottom && rowIsBorder(top) {
+		top++
+	}
+	// The whole image matches the border, trimming would leave nothing
+	if top == bottom {
+		return img
+	}
+	for bottom > top && rowIsBorder(bottom-1) {
+		bottom--
+	}
+	left, right := 0, bounds.Dx()
+	for left < right && columnIsBorder(left, top, bottom) {
+		left++
+	}
+	for right > left && columnIsBorder(right-1, top, bottom) {
+		right--
+	}
+
+	return imaging.Crop(src, image.Rect(left, top, right, bottom))
+}
+
+func colorDistance(a color.NRGBA, b color.NRGBA) int {
+	return max(absDiff(a.R, b.R), absDiff(a.G, b.G), absDiff(a.B, b.B), absDiff(a.A, b.A))
+}
+
+func absDiff(a uint8, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
@@ -0,0 +1,224 @@
+package transformations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/StrongerSoftworks/image-proxy/internal/imghttp"
+	"github.com/disintegration/imaging"
+)
+
+// Watermark is a server-configured overlay that requests select by ID, so
+// clients can never point the proxy at an arbitrary overlay image
+type Watermark struct {
+	// Source is the URL or local file path of the overlay image
+	Source string `json:"source"`
+	// Defaults used when the request does not override them
+	Gravity string  `json:"gravity"`
+	Opacity float64 `json:"opacity"`
+	Scale   float64 `json:"scale"`
+	Tile    bool    `json:"tile"`
+	// Margin is the distance in pixels from the anchored edges, and the gap between tiles
+	Margin int `json:"margin"`
+
+	mu  sync.Mutex
+	img image.Image
+}
+
+// WatermarkOptions selects a watermark and how it is placed on the output
+type WatermarkOptions struct {
+	ID      string
+	Gravity string
+	Opacity float64
+	// Scale is the watermark width relative to the output width
+	Scale float64
+	Tile  bool
+}
+
+var watermarks = map[string]*Watermark{}
+
+// reads the watermark definitions from a JSON file mapping IDs to watermarks
+func LoadWatermarks(configPath string) error {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read watermarks: %w", err)
+	}
+
+	loaded := map[string]*Watermark{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("failed to parse watermarks: %w", err)
+	}
+
+	for id, watermark := range loaded {
+		if watermark.Source == "" {
+			return fmt.Errorf("watermark %s has no source", id)
+		}
+		if watermark.Gravity == "" {
+			watermark.Gravity = SouthEast
+		}
+		if watermark.Opacity == 0 {
+			watermark.Opacity = 1
+		}
+		if watermark.Scale == 0 {
+			watermark.Scale = 0.25
+		}
+		// Tiles are spaced by the margin, a negative one would never advance
+		if !validateGravity(watermark.Gravity) || !validOpacity(watermark.Opacity) || !validScale(watermark.Scale) ||
+			watermark.Margin < 0 {
+			return fmt.Errorf("watermark %s has invalid placement", id)
+		}
+	}
+
+	watermarks = loaded
+	return nil
+}
+
+func validOpacity(opacity float64) bool {
+	return opacity > 0 && opacity <= 1
+}
+
+func validScale(scale float64) bool {
+	return scale > 0 && scale <= 1
+}
+
+// returns the decoded overlay, fetching it on first use. A failed fetch is
+// retried on the next request instead of being cached.
+func (watermark *Watermark) image() (image.Image, error) {
+	watermark.mu.Lock()
+	defer watermark.mu.Unlock()
+
+	if watermark.img != nil {
+		return watermark.img, nil
+	}
+
+	var img image.Image
+	var err error
+	if strings.HasPrefix(watermark.Source, "http://") || strings.HasPrefix(watermark.Source, "https://") {
+		img, _, err = imghttp.GetImage(watermark.Source)
+	} else {
+		img, err = imaging.Open(watermark.Source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load watermark: %w", err)
+	}
+
+	watermark.img = img
+	return img, nil
+}
+
+// parses the watermark, wm_gravity, wm_opacity, wm_scale and wm_tile query parameters
+func parseWatermark(query func(string) string, options *Options) error {
+	id := query("watermark")
+	if id == "" {
+		return nil
+	}
+	watermark, exists := watermarks[id]
+	if !exists {
+		return fmt.Errorf("unknown watermark: %s", id)
+	}
+
+	options.Watermark = WatermarkOptions{
+		ID:      id,
+		Gravity: watermark.Gravity,
+		Opacity: watermark.Opacity,
+		Scale:   watermark.Scale,
+		Tile:    watermark.Tile,
+	}
+
+	if gravityQuery := query("wm_gravity"); gravityQuery != "" {
+		if !validateGravity(gravityQuery) {
+			return fmt.Errorf("invalid wm_gravity: %s", gravityQuery)
+		}
+		options.Watermark.Gravity = gravityQuery
+	}
+
+	if opacityQuery := query("wm_opacity"); opacityQuery != "" {
+		opacity, err := strconv.ParseFloat(opacityQuery, 64)
+		if err != nil || !validOpacity(opacity) {
+			return fmt.Errorf("invalid wm_opacity: %s", opacityQuery)
+		}
+		options.Watermark.Opacity = opacity
+	}
+
+	if scaleQuery := query("wm_scale"); scaleQuery != "" {
+		scale, err := strconv.ParseFloat(scaleQuery, 64)
+		if err != nil || !validScale(scale) {
+			return fmt.Errorf("invalid wm_scale: %s", scaleQuery)
+		}
+		options.Watermark.Scale = scale
+	}
+
+	if tileQuery := query("wm_tile"); tileQuery != "" {
+		tile, err := strconv.ParseBool(tileQuery)
+		if err != nil {
+			return fmt.Errorf("invalid wm_tile: %s", tileQuery)
+		}
+		options.Watermark.Tile = tile
+	}
+
+	return nil
+}
+
+func watermarkVariant(options *Options) []string {
+	if options.Watermark.ID == "" {
+		return nil
+	}
+	part := fmt.Sprintf("wm-%s-%s-%s-%s", options.Watermark.ID, options.Watermark.Gravity,
+		formatFloat(options.Watermark.Opacity), formatFloat(options.Watermark.Scale))
+	if options.Watermark.Tile {
+		part += "-tile"
+	}
+	// The configured source and margin are part of the key so derivatives are
+	// regenerated when the watermark configuration changes
+	if watermark, exists := watermarks[options.Watermark.ID]; exists {
+		sum := sha256.Sum256([]byte(watermark.Source))
+		part += fmt.Sprintf("-%s-%d", hex.EncodeToString(sum[:6]), watermark.Margin)
+	}
+	return []string{part}
+}
+
+// composites the selected watermark over the image
+func applyWatermark(img image.Image, options *Options) (image.Image, error) {
+	if options.Watermark.ID == "" {
+		return img, nil
+	}
+	watermark, exists := watermarks[options.Watermark.ID]
+	if !exists {
+		return nil, fmt.Errorf("unknown watermark: %s", options.Watermark.ID)
+	}
+	overlay, err := watermark.image()
+	if err != nil {
+		return nil, err
+	}
+
+	result := imaging.Clone(img)
+	canvas := result.Bounds()
+	width := max(1, int(math.Round(float64(canvas.Dx())*options.Watermark.Scale)))
+	overlay = imaging.Resize(overlay, width, 0, resampleFilter(options.Filter))
+	size := overlay.Bounds().Size()
+	mask := image.NewUniform(color.Alpha{A: uint8(math.Round(options.Watermark.Opacity * 255))})
+
+	if !options.Watermark.Tile {
+		position := gravityPosition(options.Watermark.Gravity, canvas, size, watermark.Margin)
+		draw.DrawMask(result, image.Rectangle{position, position.Add(size)}, overlay, image.Point{}, mask, image.Point{}, draw.Over)
+		return result, nil
+	}
+
+	for y := 0; y < canvas.Max.Y; y += size.Y + watermark.Margin {
+		for x := 0; x < canvas.Max.X; x += size.X + watermark.Margin {
+			position := image.Pt(x, y)
+			draw.DrawMask(result, image.Rectangle{position, position.Add(size)}, overlay, image.Point{}, mask, image.Point{}, draw.Over)
+		}
+	}
+	return result, nil
+}